@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"github.com/urfave/cli/v2/altsrc"
+)
+
+// configurableFlags wraps the flags so their values can also be set by the
+// --config file
+func configurableFlags(flags []cli.Flag) []cli.Flag {
+	wrapped := make([]cli.Flag, len(flags))
+	for i, f := range flags {
+		switch f := f.(type) {
+		case *cli.BoolFlag:
+			wrapped[i] = altsrc.NewBoolFlag(f)
+		case *cli.StringFlag:
+			wrapped[i] = altsrc.NewStringFlag(f)
+		case *cli.StringSliceFlag:
+			wrapped[i] = altsrc.NewStringSliceFlag(f)
+		case *cli.IntFlag:
+			wrapped[i] = altsrc.NewIntFlag(f)
+		case *cli.DurationFlag:
+			wrapped[i] = altsrc.NewDurationFlag(f)
+		default:
+			wrapped[i] = f
+		}
+	}
+	return wrapped
+}
+
+// loadConfigFile fills in the flags that weren't set on the command line or
+// by an environment variable from the --config file. The format is picked by
+// the file extension.
+func loadConfigFile(c *cli.Context) (err error) {
+	filename := c.String("config")
+	if filename == "" {
+		return nil
+	}
+	var source altsrc.InputSourceContext
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		source, err = altsrc.NewYamlSourceFromFile(filename)
+	case ".toml":
+		source, err = altsrc.NewTomlSourceFromFile(filename)
+	case ".json":
+		source, err = altsrc.NewJSONSourceFromFile(filename)
+	default:
+		return fmt.Errorf("Unsupported config file %s, expected a .yaml, .toml or .json file", filename)
+	}
+	if err != nil {
+		return fmt.Errorf("Error reading config file %s: %w", filename, err)
+	}
+	return altsrc.ApplyInputSourceValues(c, source, c.App.Flags)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// mysqlConfig returns the driver config for connecting to the database. With
+// more than one address Addr lists all of them so the masked DSN shows every
+// host, and newConnector connects to each on its own.
+func mysqlConfig(user, password string, addrs []string, database string) *mysql.Config {
+	cfg := mysql.NewConfig()
+	cfg.User = user
+	cfg.Passwd = password
+	cfg.Net = "tcp"
+	cfg.Addr = strings.Join(addrs, ",")
+	cfg.DBName = database
+	return cfg
+}
+
+// parseHosts splits a comma-separated --host list into addresses. Each host
+// can have its own port, like replica-2:3307, and uses port otherwise.
+func parseHosts(hosts string, port int) (addrs []string, err error) {
+	for _, host := range strings.Split(hosts, ",") {
+		host = strings.TrimSpace(host)
+		if host == "" {
+			return nil, fmt.Errorf("Invalid --host %q, a host in the list is empty", hosts)
+		}
+		// A bare IPv6 address has too many colons to be split
+		if h, p, err := net.SplitHostPort(host); err == nil {
+			if _, err := strconv.Atoi(p); err != nil {
+				return nil, fmt.Errorf("Invalid --host %q, the port of %s isn't a number", hosts, host)
+			}
+			addrs = append(addrs, net.JoinHostPort(h, p))
+			continue
+		}
+		addrs = append(addrs, net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(port)))
+	}
+	return
+}
+
+// hostEntry is one of the servers of --hosts
+type hostEntry struct {
+	// name is the entry without the user, which fills in {host} and the
+	// source_host column
+	name, user, addr string
+}
+
+// parseHostList parses the comma-separated [user@]host[:port] servers of
+// --hosts. The user and port default to --user and --port.
+func parseHostList(list, user string, port int) (hosts []hostEntry, err error) {
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(list, ",") {
+		h := hostEntry{name: strings.TrimSpace(entry), user: user}
+		if i := strings.LastIndex(h.name, "@"); i >= 0 {
+			h.user, h.name = h.name[:i], h.name[i+1:]
+		}
+		if h.name == "" {
+			return nil, fmt.Errorf("Invalid --hosts %q, a host in the list is empty", list)
+		}
+		if seen[h.name] {
+			return nil, fmt.Errorf("Invalid --hosts %q, %s is listed more than once", list, h.name)
+		}
+		seen[h.name] = true
+		// A single host can only fail on its port
+		addrs, err := parseHosts(h.name, port)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid --hosts %q, the port of %s isn't a number", list, h.name)
+		}
+		h.addr = addrs[0]
+		hosts = append(hosts, h)
+	}
+	return
+}
+
+// outputHost returns the {host} of the output template, the first host of the
+// list without its port so the file name doesn't change when failing over
+func outputHost(addrs []string) string {
+	host, _, _ := net.SplitHostPort(addrs[0])
+	return host
+}
+
+// maskedDSN formats the config as a DSN that's safe to show in errors and
+// logs. The password is replaced as a whole rather than searched for in the
+// DSN, so a password that also appears in the user, host or database name
+// doesn't mask those too. An empty password is left out.
+func maskedDSN(cfg *mysql.Config) string {
+	masked := cfg.Clone()
+	if masked.Passwd != "" {
+		masked.Passwd = "******"
+	}
+	return masked.FormatDSN()
+}
+
+// timeZoneParam quotes the zone for the time_zone parameter, which the driver
+// sends as SET time_zone=<value> when it connects. UTC is written as an
+// offset since named zones need the server's time zone tables loaded.
+func timeZoneParam(zone string) string {
+	if strings.EqualFold(zone, "UTC") {
+		zone = "+00:00"
+	}
+	return "'" + strings.ReplaceAll(zone, "'", "''") + "'"
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestMySQLConfigSpecialCharacters(t *testing.T) {
+	tests := []struct {
+		name, password, database string
+	}{
+		{"slash", "pass/word", "db/name"},
+		{"at", "p@ssword", "db@name"},
+		{"colon", "pass:word", "db:name"},
+		{"question mark", "pass?word", "db?name"},
+		{"hash", "pass#word", "db#name"},
+		{"percent", "pass%word%41", "db%2Fname"},
+		{"quote", "pass'word\"", "db'name"},
+		{"parentheses", "pass(word)", "db(name)"},
+		{"ampersand and equals", "a=b&c=d", "db&name=x"},
+		{"space and backslash", `pass wo\rd`, "db name"},
+		{"every character", `/@:?#%'"()&=\ ,;!$*+[]{}<>|~^`, "tenant/@:?#%'"},
+		{"looks like a DSN", "user:pw@tcp(host:3306)/db?x=y", "other@tcp(evil:1)/db"},
+		{"unicode", "pässwörd✓", "dätenbank"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := mysqlConfig("app", tt.password, []string{"db.example.com:3306"}, tt.database)
+			parsed, err := mysql.ParseDSN(cfg.FormatDSN())
+			if err != nil {
+				t.Fatalf("parsing the DSN: %s", err)
+			}
+			if parsed.User != "app" || parsed.Passwd != tt.password || parsed.DBName != tt.database || parsed.Addr != "db.example.com:3306" {
+				t.Errorf("got user %q, password %q, database %q and address %q back, want %q, %q, %q and %q", parsed.User, parsed.Passwd, parsed.DBName, parsed.Addr, "app", tt.password, tt.database, "db.example.com:3306")
+			}
+			for _, addrs := range [][]string{{"db.example.com:3306"}, {"replica-1:3306", "replica-2:3307"}} {
+				if _, err := newConnector(mysqlConfig("app", tt.password, addrs, tt.database), addrs); err != nil {
+					t.Errorf("creating the connector for %s: %s", strings.Join(addrs, ","), err)
+				}
+			}
+
+			// The masked DSN has to be the one of a config that never had the
+			// password, so no part of the password can be left in it
+			masked := maskedDSN(cfg)
+			want := mysqlConfig("app", "******", []string{"db.example.com:3306"}, tt.database).FormatDSN()
+			if masked != want {
+				t.Errorf("got masked DSN %q, want %q", masked, want)
+			}
+			if strings.Contains(masked, tt.password) {
+				t.Errorf("the masked DSN %q contains the password", masked)
+			}
+			parsed, err = mysql.ParseDSN(masked)
+			if err != nil {
+				t.Fatalf("parsing the masked DSN: %s", err)
+			}
+			if parsed.Passwd != "******" || parsed.DBName != tt.database {
+				t.Errorf("got password %q and database %q from the masked DSN, want %q and %q", parsed.Passwd, parsed.DBName, "******", tt.database)
+			}
+		})
+	}
+}
+
+func TestMaskedDSNEmptyPassword(t *testing.T) {
+	masked := maskedDSN(mysqlConfig("app", "", []string{"localhost:3306"}, "testdb"))
+	if strings.Contains(masked, "*") {
+		t.Errorf("got %q, want no masked password", masked)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/wyattis/mysql2csv/export"
+)
+
+// Exit codes for the different classes of failure so scripts can tell them
+// apart
+const (
+	ExitFailure    = 1
+	ExitConnection = 2
+	ExitQuery      = 3
+	ExitOutput     = 4
+	ExitUsage      = 5
+)
+
+// exitError tags an error with the exit code it should cause
+type exitError struct {
+	code int
+	err  error
+	// dsn is the masked DSN of connection errors
+	dsn string
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+func usageError(err error) error {
+	return &exitError{code: ExitUsage, err: err}
+}
+
+func connectionError(dsn string, err error) error {
+	return &exitError{code: ExitConnection, err: err, dsn: dsn}
+}
+
+// exitCode returns the exit code for err. Errors returned by the database are
+// split into connection failures and problems with the query itself.
+func exitCode(err error) int {
+	var e *exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	var outputErr *export.OutputError
+	if errors.As(err, &outputErr) {
+		return ExitOutput
+	}
+	if isConnectionError(err) {
+		return ExitConnection
+	}
+	var queryErr *export.QueryError
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &queryErr) || errors.As(err, &mysqlErr) {
+		return ExitQuery
+	}
+	return ExitFailure
+}
+
+// accessErrors are the server errors caused by the credentials or database
+// in the DSN rather than by a query
+var accessErrors = map[uint16]bool{
+	1044: true, // ER_DBACCESS_DENIED_ERROR
+	1045: true, // ER_ACCESS_DENIED_ERROR
+	1049: true, // ER_BAD_DB_ERROR
+	1698: true, // ER_ACCESS_DENIED_NO_PASSWORD_ERROR
+}
+
+func isConnectionError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return accessErrors[mysqlErr.Number]
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn)
+}
+
+// errorReport is written to stderr instead of the plain error message with
+// --json-errors
+type errorReport struct {
+	Error     string `json:"error"`
+	Stage     string `json:"stage"`
+	ExitCode  int    `json:"exit_code"`
+	Query     string `json:"query,omitempty"`
+	MaskedDSN string `json:"masked_dsn,omitempty"`
+	DiskFull  bool   `json:"disk_full,omitempty"`
+}
+
+// stages names the part of the run that failed for each exit code
+var stages = map[int]string{
+	ExitFailure:    "export",
+	ExitConnection: "connect",
+	ExitQuery:      "query",
+	ExitOutput:     "write",
+	ExitUsage:      "flags",
+}
+
+func newErrorReport(err error) errorReport {
+	code := exitCode(err)
+	report := errorReport{Error: err.Error(), Stage: stages[code], ExitCode: code, DiskFull: export.IsDiskFull(err)}
+	var e *exitError
+	if errors.As(err, &e) {
+		report.MaskedDSN = e.dsn
+	}
+	var queryErr *export.QueryError
+	var outputErr *export.OutputError
+	if errors.As(err, &queryErr) {
+		report.Query = queryErr.Query
+		report.MaskedDSN = queryErr.DSN
+	} else if errors.As(err, &outputErr) {
+		report.Query = outputErr.Query
+	}
+	return report
+}
+
+// printError writes the error to stderr as a message or, with jsonErrors, as
+// an errorReport
+func printError(err error) {
+	if !jsonErrors {
+		slog.Error(err.Error())
+		return
+	}
+	json.NewEncoder(os.Stderr).Encode(newErrorReport(err))
+}
@@ -0,0 +1,128 @@
+package export
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// AddedColumn is a column with the same value in every row that
+// Exporter.AddColumns adds to each result set
+type AddedColumn struct {
+	Name  string
+	Value string
+}
+
+// ParseAddColumns parses name=value flags into the columns to add
+func ParseAddColumns(specs []string) (columns []AddedColumn, err error) {
+	for _, spec := range specs {
+		name, value, ok := strings.Cut(spec, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("Invalid --add-column %q, expected name=value", spec)
+		}
+		for _, c := range columns {
+			if c.Name == name {
+				return nil, fmt.Errorf("Invalid --add-column %q, the column %s is already added", spec, name)
+			}
+		}
+		columns = append(columns, AddedColumn{Name: name, Value: value})
+	}
+	return
+}
+
+// addedColumnTypes asks the server for the types of the added columns, so
+// they're written like any other text column. It has to run before the query
+// since the connection is busy until the rows have been read.
+func addedColumnTypes(ctx context.Context, conn *sql.Conn, columns []AddedColumn) (types []*sql.ColumnType, err error) {
+	if len(columns) == 0 {
+		return
+	}
+	exprs := make([]string, len(columns))
+	for i, c := range columns {
+		exprs[i] = fmt.Sprintf("CONVERT(X'%x' USING utf8mb4) AS %s", c.Value, quoteIdentifier(c.Name))
+	}
+	rows, err := conn.QueryContext(ctx, "SELECT "+strings.Join(exprs, ", "))
+	if err != nil {
+		return nil, fmt.Errorf("getting the types of the added columns: %w", err)
+	}
+	defer rows.Close()
+	return rows.ColumnTypes()
+}
+
+// withAddedColumns adds the AddColumns to the rows, in front of their columns
+// with PrependColumns or after them otherwise
+func (e *Exporter) withAddedColumns(rows resultRows, types []*sql.ColumnType) resultRows {
+	if len(e.AddColumns) == 0 {
+		return rows
+	}
+	added := &addedRows{resultRows: rows, columns: e.AddColumns, types: types, prepend: e.PrependColumns}
+	for _, c := range e.AddColumns {
+		added.values = append(added.values, sql.RawBytes(c.Value))
+	}
+	return added
+}
+
+// addedRows are rows with constant columns added to every row of each of
+// their result sets
+type addedRows struct {
+	resultRows
+	columns []AddedColumn
+	types   []*sql.ColumnType
+	values  []sql.RawBytes
+	prepend bool
+}
+
+// placeAdded returns row with the added values in front or after it
+func placeAdded[T any](a *addedRows, row, added []T) []T {
+	if a.prepend {
+		return slices.Concat(added, row)
+	}
+	return slices.Concat(row, added)
+}
+
+func (a *addedRows) Columns() ([]string, error) {
+	columns, err := a.resultRows.Columns()
+	// Statements without a result set stay that way
+	if err != nil || len(columns) == 0 {
+		return columns, err
+	}
+	names := make([]string, len(a.columns))
+	for i, c := range a.columns {
+		// MySQL doesn't tell column names apart by case either
+		if slices.ContainsFunc(columns, func(name string) bool { return strings.EqualFold(name, c.Name) }) {
+			return nil, fmt.Errorf("Can't add the column %s, the result set already has a column with that name", c.Name)
+		}
+		names[i] = c.Name
+	}
+	return placeAdded(a, columns, names), nil
+}
+
+func (a *addedRows) ColumnTypes() ([]*sql.ColumnType, error) {
+	types, err := a.resultRows.ColumnTypes()
+	if err != nil || len(types) == 0 {
+		return types, err
+	}
+	return placeAdded(a, types, a.types), nil
+}
+
+// Scan reads the columns of the query into their part of dest and sets the
+// added values in the rest
+func (a *addedRows) Scan(dest ...any) error {
+	row, added := dest[:len(dest)-len(a.columns)], dest[len(dest)-len(a.columns):]
+	if a.prepend {
+		added, row = dest[:len(a.columns)], dest[len(a.columns):]
+	}
+	if err := a.resultRows.Scan(row...); err != nil {
+		return err
+	}
+	for i, d := range added {
+		raw, ok := d.(*sql.RawBytes)
+		if !ok {
+			return fmt.Errorf("can't scan the added column %s into %T", a.columns[i].Name, d)
+		}
+		*raw = a.values[i]
+	}
+	return nil
+}
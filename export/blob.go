@@ -0,0 +1,121 @@
+package export
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// unsafeFileName matches the characters that aren't kept in file names made
+// from values
+var unsafeFileName = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// blobWriter writes the values of the blob columns of a result set to files
+// of their own and replaces them with the path of the file. The files of each
+// output go in a directory named after it, <dir>/<output>/<column>/, so
+// result sets never share a file.
+type blobWriter struct {
+	dir string
+	// prefix is what the path written in the cells starts with
+	prefix  string
+	columns map[int]string
+	key     int
+	// names are the files written so far, so a repeated key gets a suffix
+	// and repeated content is only written once
+	names map[string]bool
+	files int
+	bytes int64
+}
+
+// newBlobWriter returns nil if none of the columns are in the result set.
+// Paths are written relative to the directory of a local output file so the
+// two can be moved together.
+func newBlobWriter(dir, output string, index int, columns, blobColumns []string, keyColumn string) (*blobWriter, error) {
+	w := &blobWriter{columns: map[int]string{}, key: -1, names: map[string]bool{}}
+	for i, c := range columns {
+		if indexOf(blobColumns, c) >= 0 {
+			w.columns[i] = c
+		}
+	}
+	if len(w.columns) == 0 {
+		return nil, nil
+	}
+	if keyColumn != "" {
+		if w.key = indexOf(columns, keyColumn); w.key < 0 {
+			return nil, fmt.Errorf("the blob key column %s isn't in the result set", keyColumn)
+		}
+	}
+	stem := fmt.Sprintf("result-set-%d", index)
+	if output != "" {
+		stem, _ = splitExtensions(filepath.Base(output))
+	}
+	w.dir = filepath.Join(dir, stem)
+	w.prefix = w.dir
+	if isLocalFile(output) {
+		if rel, err := filepath.Rel(filepath.Dir(output), w.dir); err == nil {
+			w.prefix = rel
+		}
+	}
+	return w, nil
+}
+
+// write moves the blobs of the row into their files
+func (w *blobWriter) write(values []sql.NullString) error {
+	for i, column := range w.columns {
+		v := values[i]
+		if !v.Valid {
+			continue
+		}
+		name, write := w.name(column, values, v.String)
+		path := filepath.Join(w.dir, column, name)
+		if write {
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(path, []byte(v.String), 0o644); err != nil {
+				return err
+			}
+			w.files++
+			w.bytes += int64(len(v.String))
+		}
+		values[i].String = filepath.ToSlash(filepath.Join(w.prefix, column, name))
+	}
+	return nil
+}
+
+// name returns the file name of a blob and whether it still has to be
+// written. Names come from the key column or from the SHA-256 of the content
+// so identical blobs share a file. A key that was already used gets a suffix
+// so the names stay unique and only depend on the order of the rows.
+func (w *blobWriter) name(column string, values []sql.NullString, blob string) (string, bool) {
+	if w.key < 0 {
+		sum := sha256.Sum256([]byte(blob))
+		name := hex.EncodeToString(sum[:])
+		seen := w.names[column+"/"+name]
+		w.names[column+"/"+name] = true
+		return name, !seen
+	}
+	name := safeFileName(values[w.key].String)
+	unique := name
+	for n := 2; w.names[column+"/"+unique]; n++ {
+		unique = fmt.Sprintf("%s_%d", name, n)
+	}
+	w.names[column+"/"+unique] = true
+	return unique, true
+}
+
+// safeFileName replaces the characters of value that aren't safe in a file
+// name. Values that had to be changed get a short hash of the original so
+// a/b and a_b don't end up with the same name.
+func safeFileName(value string) string {
+	name := unsafeFileName.ReplaceAllString(value, "_")
+	if name != value || name == "" || name == "." || name == ".." {
+		sum := sha256.Sum256([]byte(value))
+		name += "_" + hex.EncodeToString(sum[:4])
+	}
+	return name
+}
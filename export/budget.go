@@ -0,0 +1,58 @@
+package export
+
+import "sync"
+
+// rowBudget is the number of rows left before MaxRowsTotal is reached. It's
+// shared by the exporters of a parallel export.
+type rowBudget struct {
+	mu   sync.Mutex
+	left int
+	// reached is set once a row, result set or query was skipped
+	reached bool
+}
+
+func newRowBudget(rows int) *rowBudget {
+	if rows <= 0 {
+		return nil
+	}
+	return &rowBudget{left: rows}
+}
+
+// take uses up a row of the budget. It returns false when there are none left.
+func (b *rowBudget) take() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.left == 0 {
+		b.reached = true
+		return false
+	}
+	b.left--
+	return true
+}
+
+// spent reports whether the budget is used up, recording that whatever
+// was about to be exported is skipped
+func (b *rowBudget) spent() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.left == 0 {
+		b.reached = true
+	}
+	return b.left == 0
+}
+
+// wasReached reports whether anything was skipped because of the budget
+func (b *rowBudget) wasReached() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.reached
+}
@@ -0,0 +1,66 @@
+package export
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"path"
+)
+
+// checksumWriter hashes everything written to the output and writes a
+// companion .sha256 file when it is closed
+type checksumWriter struct {
+	output   io.WriteCloser
+	hash     hash.Hash
+	writer   io.Writer
+	filename string
+	data     OutputData
+}
+
+func newChecksumWriter(output io.WriteCloser, filename string, data OutputData) *checksumWriter {
+	h := sha256.New()
+	return &checksumWriter{
+		output:   output,
+		hash:     h,
+		writer:   io.MultiWriter(output, h),
+		filename: filename,
+		data:     data,
+	}
+}
+
+func (w *checksumWriter) Write(p []byte) (int, error) {
+	return w.writer.Write(p)
+}
+
+// Close closes the output and then writes the checksum. Output written to
+// stdout has its checksum written to stderr instead.
+func (w *checksumWriter) Close() (err error) {
+	if err = w.output.Close(); err != nil {
+		return
+	}
+	sum := hex.EncodeToString(w.hash.Sum(nil))
+	if w.filename == "" {
+		slog.Info(fmt.Sprintf("%s  -", sum), "sha256", sum)
+		return
+	}
+	sidecar, err := openDestination(w.data, w.filename+".sha256")
+	if err != nil {
+		return fmt.Errorf("Error writing checksum: %w", err)
+	}
+	// sha256sum expects the name of the file relative to the checksum file
+	_, err = fmt.Fprintf(sidecar, "%s  %s\n", sum, path.Base(w.filename))
+	if cerr := sidecar.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("Error writing checksum: %w", err)
+	}
+	return
+}
+
+func (w *checksumWriter) Abort(err error) {
+	abortOrClose(w.output, err)
+}
@@ -0,0 +1,77 @@
+package export
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressNone = "none"
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+)
+
+func ValidateCompression(mode string) error {
+	switch mode {
+	case "", CompressNone, CompressGzip, CompressZstd:
+		return nil
+	}
+	return fmt.Errorf("Invalid compression %q, expected one of none, gzip or zstd", mode)
+}
+
+// CompressionFor returns the compression to use for the given output file. An
+// explicit mode always wins, otherwise it is detected from the file extension.
+func CompressionFor(mode, filename string) string {
+	if mode != "" {
+		return mode
+	}
+	switch {
+	case strings.HasSuffix(filename, ".gz"):
+		return CompressGzip
+	case strings.HasSuffix(filename, ".zst"), strings.HasSuffix(filename, ".zstd"):
+		return CompressZstd
+	}
+	return CompressNone
+}
+
+// compressOutput wraps output with the compressor for mode
+func compressOutput(mode string, output io.WriteCloser) (io.WriteCloser, error) {
+	var compressor io.WriteCloser
+	switch mode {
+	case "", CompressNone:
+		return output, nil
+	case CompressGzip:
+		compressor = gzip.NewWriter(output)
+	case CompressZstd:
+		zw, err := zstd.NewWriter(output)
+		if err != nil {
+			return nil, err
+		}
+		compressor = zw
+	default:
+		return nil, ValidateCompression(mode)
+	}
+	return &compressedWriter{WriteCloser: compressor, output: output}, nil
+}
+
+type compressedWriter struct {
+	io.WriteCloser
+	output io.WriteCloser
+}
+
+// Close flushes the compressor before closing the underlying output
+func (w *compressedWriter) Close() (err error) {
+	err = w.WriteCloser.Close()
+	if cerr := w.output.Close(); err == nil {
+		err = cerr
+	}
+	return
+}
+
+func (w *compressedWriter) Abort(err error) {
+	abortOrClose(w.output, err)
+}
@@ -0,0 +1,194 @@
+package export
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// QuoteMinimal only quotes the fields that need it, like encoding/csv
+	QuoteMinimal = "minimal"
+	// QuoteAll quotes every field, including the header
+	QuoteAll = "all"
+	// QuoteNone never quotes and fails on a value that would need quotes
+	QuoteNone = "none"
+)
+
+// csvWriter writes RFC 4180 CSV like encoding/csv but with a configurable
+// quote character and quoting per column, which encoding/csv doesn't support
+type csvWriter struct {
+	w     *bufio.Writer
+	comma rune
+	quote rune
+	mode  string
+	// quoteEmpty quotes empty strings so they aren't mistaken for NULL
+	quoteEmpty bool
+	// forceQuote is set for the columns whose values are always quoted
+	forceQuote []bool
+	columns    []string
+	// escaper escapes the values of unquoted fields with QuoteNone and an
+	// escape character, and null is what NULL is written as
+	escaper *strings.Replacer
+	null    string
+}
+
+func newCSVWriter(output io.Writer, types []*sql.ColumnType, opts WriteOptions) *csvWriter {
+	w := &csvWriter{w: bufio.NewWriter(output), comma: ',', quote: '"', mode: opts.Quote, quoteEmpty: opts.QuoteEmpty}
+	if opts.QuoteChar != "" {
+		w.quote, _ = utf8.DecodeRuneInString(opts.QuoteChar)
+	}
+	if opts.EscapeChar != "" && opts.Quote == QuoteNone {
+		// The same characters as SELECT ... INTO OUTFILE escapes, plus
+		// carriage returns so a line can't end early on Windows
+		e := opts.EscapeChar
+		w.escaper = strings.NewReplacer(e, e+e, string(w.comma), e+string(w.comma), "\n", e+"\n", "\r", e+"\r", "\x00", e+"0")
+		w.null = e + "N"
+	}
+	for _, t := range types {
+		w.columns = append(w.columns, t.Name())
+		w.forceQuote = append(w.forceQuote, indexOf(opts.QuoteColumns, t.Name()) >= 0)
+	}
+	return w
+}
+
+// ValidateQuote checks the --quote mode
+func ValidateQuote(mode string) error {
+	switch mode {
+	case "", QuoteMinimal, QuoteAll, QuoteNone:
+		return nil
+	}
+	return fmt.Errorf("Invalid quoting %q, expected one of %s, %s or %s", mode, QuoteMinimal, QuoteAll, QuoteNone)
+}
+
+// ValidateEscapeChar checks that the escape is a single character that isn't
+// already part of the CSV syntax
+func ValidateEscapeChar(escape string) error {
+	r, size := utf8.DecodeRuneInString(escape)
+	if size != len(escape) || r == utf8.RuneError || r == ',' || r == '\r' || r == '\n' || r == 'N' || r == '0' {
+		return fmt.Errorf("Invalid escape character %q, expected a single character other than a comma, line break, N or 0", escape)
+	}
+	return nil
+}
+
+// ValidateQuoteChar checks that the quote is a single character that can't be
+// confused with the rest of the CSV syntax
+func ValidateQuoteChar(quote string) error {
+	r, size := utf8.DecodeRuneInString(quote)
+	if size != len(quote) || r == utf8.RuneError || r == ',' || r == '\r' || r == '\n' {
+		return fmt.Errorf("Invalid quote character %q, expected a single character other than a comma or line break", quote)
+	}
+	return nil
+}
+
+func (w *csvWriter) WriteHeader(columns []string) error {
+	var quoted []bool
+	if w.mode == QuoteAll {
+		quoted = make([]bool, len(columns))
+		for i := range quoted {
+			quoted[i] = true
+		}
+	}
+	if w.escaper != nil {
+		escaped := make([]string, len(columns))
+		for i, c := range columns {
+			escaped[i] = w.escaper.Replace(c)
+		}
+		columns = escaped
+	}
+	return w.write(columns, quoted)
+}
+
+func (w *csvWriter) WriteRow(values []sql.NullString) error {
+	record := make([]string, len(values))
+	var quoted []bool
+	if w.mode == QuoteAll || len(w.forceQuote) > 0 || w.quoteEmpty {
+		quoted = make([]bool, len(values))
+	}
+	for i, v := range values {
+		record[i] = v.String
+		if w.escaper != nil && !w.forced(i) {
+			record[i] = w.null
+			if v.Valid {
+				record[i] = w.escaper.Replace(v.String)
+			}
+		}
+		if quoted != nil {
+			// NULL is left as an empty field so the two can be told apart
+			quoted[i] = w.forced(i) || w.quoteEmpty && v.Valid && v.String == ""
+		}
+	}
+	return w.write(record, quoted)
+}
+
+// forced reports whether the values of the column are always quoted. The
+// columns of a transposed result set aren't the ones of the query so only
+// QuoteAll applies to them.
+func (w *csvWriter) forced(column int) bool {
+	return w.mode == QuoteAll || column < len(w.forceQuote) && w.forceQuote[column]
+}
+
+// write writes a single record. The fields set in quoted are always quoted.
+func (w *csvWriter) write(record []string, quoted []bool) (err error) {
+	for i, field := range record {
+		if i > 0 {
+			if _, err = w.w.WriteRune(w.comma); err != nil {
+				return
+			}
+		}
+		forced := quoted != nil && quoted[i]
+		if !forced && w.mode == QuoteNone && w.escaper == nil && w.needsQuotes(field) {
+			if i >= len(w.columns) {
+				return fmt.Errorf("the value %q needs quotes but quoting is turned off", field)
+			}
+			return fmt.Errorf("the value %q in column %s needs quotes but quoting is turned off", field, w.columns[i])
+		}
+		if !forced && (w.mode == QuoteNone || !w.needsQuotes(field)) {
+			if _, err = w.w.WriteString(field); err != nil {
+				return
+			}
+			continue
+		}
+		if err = w.writeQuoted(field); err != nil {
+			return
+		}
+	}
+	return w.w.WriteByte('\n')
+}
+
+// writeQuoted writes the field between quotes, doubling any quotes inside it
+func (w *csvWriter) writeQuoted(field string) (err error) {
+	if _, err = w.w.WriteRune(w.quote); err != nil {
+		return
+	}
+	q := string(w.quote)
+	if _, err = w.w.WriteString(strings.ReplaceAll(field, q, q+q)); err != nil {
+		return
+	}
+	_, err = w.w.WriteRune(w.quote)
+	return
+}
+
+// needsQuotes follows the same rules as encoding/csv so the default output
+// doesn't change
+func (w *csvWriter) needsQuotes(field string) bool {
+	if field == "" {
+		return false
+	}
+	if field == `\.` {
+		return true
+	}
+	if strings.ContainsRune(field, w.comma) || strings.ContainsRune(field, w.quote) || strings.ContainsAny(field, "\r\n") {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(field)
+	return unicode.IsSpace(r)
+}
+
+func (w *csvWriter) Flush() error {
+	return w.w.Flush()
+}
@@ -0,0 +1,119 @@
+package export
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"testing"
+)
+
+// writeCSV writes the values as a single row through the csvWriter
+func writeCSV(t *testing.T, opts WriteOptions, values ...sql.NullString) (string, error) {
+	t.Helper()
+	var b bytes.Buffer
+	w := newCSVWriter(&b, nil, opts)
+	if err := w.WriteRow(values); err != nil {
+		return "", err
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String(), nil
+}
+
+func TestCSVMatchesEncodingCSV(t *testing.T) {
+	tests := [][]string{
+		{"plain", "1", "two words"},
+		{`say "hi"`, `"`, `""`},
+		{"a,b", ","},
+		{"line\nbreak", "carriage\rreturn", "both\r\n"},
+		{" leading space", "\tleading tab", "trailing space "},
+		{"", "empty", ""},
+		{`\.`, `\.x`},
+		{"ünïcödé", "日本"},
+	}
+	for _, record := range tests {
+		var want bytes.Buffer
+		cw := csv.NewWriter(&want)
+		if err := cw.Write(record); err != nil {
+			t.Fatal(err)
+		}
+		cw.Flush()
+		values := make([]sql.NullString, len(record))
+		for i, v := range record {
+			values[i] = sql.NullString{String: v, Valid: true}
+		}
+		got, err := writeCSV(t, WriteOptions{}, values...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want.String() {
+			t.Errorf("%q: got %q, want %q", record, got, want.String())
+		}
+	}
+}
+
+func TestCSVQuoteChar(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"it's", "'it''s'\n"},
+		{`say "hi"`, "say \"hi\"\n"},
+		{"a,b", "'a,b'\n"},
+		{"''", "''''''\n"},
+	}
+	for _, tt := range tests {
+		got, err := writeCSV(t, WriteOptions{QuoteChar: "'"}, sql.NullString{String: tt.value, Valid: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestCSVEscapeChar(t *testing.T) {
+	tests := []struct {
+		opts  WriteOptions
+		value sql.NullString
+		want  string
+	}{
+		{WriteOptions{Quote: QuoteNone, EscapeChar: `\`}, sql.NullString{String: `C:\temp`, Valid: true}, `C:\\temp` + "\n"},
+		{WriteOptions{Quote: QuoteNone, EscapeChar: `\`}, sql.NullString{String: "a,b", Valid: true}, `a\,b` + "\n"},
+		{WriteOptions{Quote: QuoteNone, EscapeChar: `\`}, sql.NullString{String: "line\nbreak", Valid: true}, "line\\\nbreak\n"},
+		{WriteOptions{Quote: QuoteNone, EscapeChar: `\`}, sql.NullString{String: "cr\r", Valid: true}, "cr\\\r\n"},
+		{WriteOptions{Quote: QuoteNone, EscapeChar: `\`}, sql.NullString{String: "nul\x00", Valid: true}, `nul\0` + "\n"},
+		{WriteOptions{Quote: QuoteNone, EscapeChar: `\`}, sql.NullString{}, `\N` + "\n"},
+		{WriteOptions{Quote: QuoteNone, EscapeChar: `\`}, sql.NullString{String: `say "hi"`, Valid: true}, `say "hi"` + "\n"},
+		{WriteOptions{Quote: QuoteNone, EscapeChar: "|"}, sql.NullString{String: "a|b,c", Valid: true}, "a||b|,c\n"},
+		// The escape only applies with --quote none, quoted fields are
+		// written as usual
+		{WriteOptions{EscapeChar: `\`}, sql.NullString{String: `a,b\c`, Valid: true}, `"a,b\c"` + "\n"},
+		{WriteOptions{EscapeChar: `\`}, sql.NullString{}, "\n"},
+	}
+	for _, tt := range tests {
+		got, err := writeCSV(t, tt.opts, tt.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestCSVQuoteNone(t *testing.T) {
+	got, err := writeCSV(t, WriteOptions{Quote: QuoteNone}, sql.NullString{String: "plain", Valid: true}, sql.NullString{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "plain,\n" {
+		t.Errorf("got %q, want %q", got, "plain,\n")
+	}
+	for _, v := range []string{"a,b", "line\nbreak", `say "hi"`} {
+		if _, err := writeCSV(t, WriteOptions{Quote: QuoteNone}, sql.NullString{String: v, Valid: true}); err == nil {
+			t.Errorf("%q: expected an error without an escape character", v)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package export
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// ParseDatabases returns the databases of a comma-separated list, or of a
+// file with one database per line when fromFile is set. Blank lines and
+// lines starting with # are skipped.
+func ParseDatabases(list string, fromFile bool) (databases []string, err error) {
+	items := strings.Split(list, ",")
+	if fromFile {
+		items = strings.Split(list, "\n")
+	}
+	seen := map[string]bool{}
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" || fromFile && strings.HasPrefix(item, "#") {
+			continue
+		}
+		if seen[item] {
+			return nil, fmt.Errorf("The database %s is listed more than once", item)
+		}
+		seen[item] = true
+		databases = append(databases, item)
+	}
+	if len(databases) == 0 {
+		return nil, fmt.Errorf("No databases were listed")
+	}
+	return
+}
+
+// DatabaseQueries repeats the queries for each of the databases, in order.
+// Every query of the first database runs before those of the second.
+func DatabaseQueries(queries []Query, databases []string) (all []Query) {
+	for _, database := range databases {
+		for _, query := range queries {
+			query.Database = database
+			all = append(all, query)
+		}
+	}
+	return
+}
+
+// useDatabase makes the database of the query the default of the connection
+// so its unqualified table names are looked up there. dsn identifies the
+// server in errors.
+func useDatabase(ctx context.Context, conn *sql.Conn, query Query, dsn string) error {
+	if query.Database == "" {
+		return nil
+	}
+	use := "USE " + quoteIdentifier(query.Database)
+	if _, err := conn.ExecContext(ctx, use); err != nil {
+		return &QueryError{Query: use, DSN: dsn, Err: err}
+	}
+	return nil
+}
+
+// LogDatabaseSummary logs the number of rows exported from each database,
+// including the ones that had no rows
+func (e *Exporter) LogDatabaseSummary() {
+	var databases []string
+	rows := map[string]int{}
+	for _, r := range e.Results {
+		if r.Query.Database == "" {
+			continue
+		}
+		if _, ok := rows[r.Query.Database]; !ok {
+			databases = append(databases, r.Query.Database)
+		}
+		rows[r.Query.Database] += r.Rows
+	}
+	for _, database := range databases {
+		slog.Info(fmt.Sprintf("%s: %d rows", database, rows[database]), "database", database, "rows", rows[database])
+	}
+}
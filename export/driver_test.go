@@ -0,0 +1,147 @@
+package export
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// testResultSet is a result set returned by the test driver. Err, when set,
+// is returned by Next after the rows instead of the end of the result set.
+type testResultSet struct {
+	columns []string
+	rows    [][]string
+	err     error
+}
+
+// testDB returns a database whose queries return the result sets listed for
+// them. Other statements succeed without returning rows.
+func testDB(t *testing.T, queries map[string][]testResultSet) *sql.DB {
+	t.Helper()
+	db := sql.OpenDB(testConnector{queries})
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+type testConnector struct {
+	queries map[string][]testResultSet
+}
+
+func (c testConnector) Connect(context.Context) (driver.Conn, error) {
+	return testConn(c), nil
+}
+
+func (c testConnector) Driver() driver.Driver {
+	return testDriver{}
+}
+
+type testDriver struct{}
+
+func (testDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("the test driver is only used through its connector")
+}
+
+type testConn testConnector
+
+func (c testConn) Prepare(query string) (driver.Stmt, error) {
+	return testStmt{sets: c.queries[query]}, nil
+}
+
+func (testConn) Close() error {
+	return nil
+}
+
+func (testConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("the test driver doesn't support transactions")
+}
+
+type testStmt struct {
+	sets []testResultSet
+}
+
+func (testStmt) Close() error {
+	return nil
+}
+
+func (testStmt) NumInput() int {
+	return -1
+}
+
+func (testStmt) Exec([]driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+
+func (s testStmt) Query([]driver.Value) (driver.Rows, error) {
+	if len(s.sets) == 0 {
+		return nil, errors.New("the query has no result sets")
+	}
+	return &testDriverRows{sets: s.sets}, nil
+}
+
+type testDriverRows struct {
+	sets     []testResultSet
+	set, row int
+}
+
+func (r *testDriverRows) Columns() []string {
+	return r.sets[r.set].columns
+}
+
+func (r *testDriverRows) Close() error {
+	return nil
+}
+
+func (r *testDriverRows) Next(dest []driver.Value) error {
+	set := r.sets[r.set]
+	if r.row == len(set.rows) {
+		if set.err != nil {
+			return set.err
+		}
+		return io.EOF
+	}
+	for i, v := range set.rows[r.row] {
+		dest[i] = []byte(v)
+	}
+	r.row++
+	return nil
+}
+
+func (r *testDriverRows) HasNextResultSet() bool {
+	return r.set+1 < len(r.sets)
+}
+
+func (r *testDriverRows) NextResultSet() error {
+	if !r.HasNextResultSet() {
+		return io.EOF
+	}
+	r.set, r.row = r.set+1, 0
+	return nil
+}
+
+func (r *testDriverRows) ColumnTypeDatabaseTypeName(int) string {
+	return "VARCHAR"
+}
+
+// testRows returns the rows of a query of a testDB with the result set
+func testRows(t *testing.T, set testResultSet) *sql.Rows {
+	t.Helper()
+	rows, err := testDB(t, map[string][]testResultSet{"q": {set}}).Query("q")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rows.Close() })
+	return rows
+}
+
+// numberedRows returns n rows of an id and a name column
+func numberedRows(n int) testResultSet {
+	set := testResultSet{columns: []string{"id", "name"}}
+	for i := 1; i <= n; i++ {
+		set.rows = append(set.rows, []string{fmt.Sprint(i), fmt.Sprintf("name %d", i)})
+	}
+	return set
+}
@@ -0,0 +1,95 @@
+package export
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/transform"
+)
+
+const (
+	EncodingErrorsReplace = "replace"
+	EncodingErrorsFail    = "error"
+)
+
+func ValidateEncodingErrors(mode string) error {
+	switch mode {
+	case EncodingErrorsReplace, EncodingErrorsFail:
+		return nil
+	}
+	return fmt.Errorf("Invalid --encoding-errors %q, expected replace or error", mode)
+}
+
+// LookupEncoding returns the encoding for a name such as latin1, windows-1252
+// or shift_jis. A nil encoding means the output stays UTF-8.
+func LookupEncoding(name string) (encoding.Encoding, error) {
+	switch strings.ToLower(name) {
+	case "", "utf8", "utf-8":
+		return nil, nil
+	}
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("Unknown encoding %q", name)
+	}
+	return enc, nil
+}
+
+// encodeOutput converts everything written to the returned writer from UTF-8
+// to the encoding. Characters the encoding can't represent are replaced with
+// its substitute character, 0x1A for single byte character sets, unless
+// errorMode is error.
+func encodeOutput(name, errorMode string, output io.WriteCloser) (io.WriteCloser, error) {
+	enc, err := LookupEncoding(name)
+	if err != nil || enc == nil {
+		return output, err
+	}
+	encoder := enc.NewEncoder()
+	if errorMode != EncodingErrorsFail {
+		encoder = encoding.ReplaceUnsupported(encoder)
+	}
+	return &encodedWriter{WriteCloser: transform.NewWriter(output, encoder), output: output}, nil
+}
+
+// encodableTransform returns the transform that fails on values the encoding
+// can't represent. The encoder would only fail once the buffered output is
+// flushed, without saying which value was the problem.
+func encodableTransform(name string) (valueTransform, error) {
+	enc, err := LookupEncoding(name)
+	if err != nil || enc == nil {
+		return nil, err
+	}
+	encoder := enc.NewEncoder()
+	return func(_ int, v sql.NullString) (sql.NullString, error) {
+		if _, err := encoder.String(v.String); err == nil {
+			return v, nil
+		}
+		for _, r := range v.String {
+			if _, err := encoder.String(string(r)); err != nil {
+				return v, fmt.Errorf("%q can't be represented in %s", r, name)
+			}
+		}
+		return v, fmt.Errorf("the value can't be represented in %s", name)
+	}, nil
+}
+
+type encodedWriter struct {
+	io.WriteCloser
+	output io.WriteCloser
+}
+
+// Close flushes the encoder before closing the underlying output
+func (w *encodedWriter) Close() (err error) {
+	err = w.WriteCloser.Close()
+	if cerr := w.output.Close(); err == nil {
+		err = cerr
+	}
+	return
+}
+
+func (w *encodedWriter) Abort(err error) {
+	abortOrClose(w.output, err)
+}
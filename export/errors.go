@@ -0,0 +1,44 @@
+package export
+
+import (
+	"errors"
+	"runtime"
+	"syscall"
+)
+
+// OutputError is returned when writing to an output fails, as opposed to the
+// query failing, so callers can tell a full disk or failed upload apart from
+// a problem with the database
+type OutputError struct {
+	// Query is the query whose result set was being written, if any
+	Query string
+	Err   error
+}
+
+func (e *OutputError) Error() string {
+	if IsDiskFull(e.Err) {
+		return e.Err.Error() + ": the disk is full, free up space or write the output to another volume"
+	}
+	return e.Err.Error()
+}
+
+func (e *OutputError) Unwrap() error {
+	return e.Err
+}
+
+func outputError(err error) error {
+	return &OutputError{Err: err}
+}
+
+// IsDiskFull reports whether err was caused by the disk running out of space
+func IsDiskFull(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		// ERROR_HANDLE_DISK_FULL and ERROR_DISK_FULL
+		return errno == 39 || errno == 112
+	}
+	return errno == syscall.ENOSPC
+}
@@ -0,0 +1,57 @@
+package export
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// formulaStart are the characters Excel and Google Sheets treat as the start
+// of a formula
+const formulaStart = "=+-@"
+
+// Prefixes for SafeExcelPrefix
+const (
+	SafeExcelQuote = "quote"
+	SafeExcelTab   = "tab"
+)
+
+// ParseSafeExcelPrefix returns the text --safe-excel-prefix puts in front of
+// values that would be read as formulas
+func ParseSafeExcelPrefix(name string) (string, error) {
+	switch name {
+	case SafeExcelQuote:
+		return "'", nil
+	case SafeExcelTab:
+		return "\t", nil
+	}
+	return "", fmt.Errorf("Invalid --safe-excel-prefix %q, expected %s or %s", name, SafeExcelQuote, SafeExcelTab)
+}
+
+// isStringType reports whether MySQL sends the column as text. Numeric
+// columns are never sanitized so negative numbers stay numbers.
+func isStringType(t *sql.ColumnType) bool {
+	switch t.DatabaseTypeName() {
+	case "CHAR", "VARCHAR", "TEXT", "TINYTEXT", "MEDIUMTEXT", "LONGTEXT", "ENUM", "SET",
+		"BINARY", "VARBINARY", "BLOB", "TINYBLOB", "MEDIUMBLOB", "LONGBLOB":
+		return true
+	}
+	return false
+}
+
+// safeExcelTransform returns the transform that puts prefix in front of the
+// string values Excel would evaluate as a formula. count is incremented for
+// every value that was changed.
+func safeExcelTransform(types []*sql.ColumnType, prefix string, count *int) valueTransform {
+	isString := make([]bool, len(types))
+	for i, t := range types {
+		isString[i] = isStringType(t)
+	}
+	return func(column int, v sql.NullString) (sql.NullString, error) {
+		if isString[column] && v.String != "" && strings.ContainsRune(formulaStart, rune(v.String[0])) {
+			v.String = prefix + v.String
+			*count++
+		}
+		return v, nil
+	}
+}
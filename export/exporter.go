@@ -0,0 +1,1250 @@
+// Package export runs queries against MySQL and writes every result set they
+// return as CSV, TSV, JSON or an aligned table to stdout, files, S3 or HTTP.
+// It is the library behind the mysql2csv command.
+package export
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// Exporter runs queries and writes each of their result sets to the output.
+// The file number and column checks carry over between calls to Export so
+// several queries can share one output template.
+type Exporter struct {
+	// DB is the database the queries run on. If it's nil Run opens DSN
+	// with the mysql driver.
+	DB  *sql.DB
+	DSN string
+	// MaskedDSN identifies the database in error messages
+	MaskedDSN string
+	// Queries are the queries exported by Run
+	Queries []Query
+	// Jobs is how many queries Run exports at a time
+	Jobs int
+	// PaginateColumn makes Run export its single query in pages of PageSize
+	// rows using keyset pagination on the column
+	PaginateColumn string
+	PageSize       int
+	Output         OutputData
+	WriteOptions
+	// SkipEmptyResultSets doesn't create a file, or write a header, for result
+	// sets without any rows. Their file numbers are still used up so the
+	// numbering doesn't depend on which result sets were empty.
+	SkipEmptyResultSets bool
+	// KeepGoing records the queries that fail and carries on with the rest
+	// instead of stopping at the first error
+	KeepGoing bool
+	// InitCommands are run on the connection before each query, e.g. to set
+	// the time zone or sql_mode of the session
+	InitCommands []string
+	// ReadOnly sets the session of each connection to read only before running
+	// the query so an accidental UPDATE or DELETE fails instead of changing
+	// the data
+	ReadOnly bool
+	// SingleTransaction runs every query on one connection inside a START
+	// TRANSACTION WITH CONSISTENT SNAPSHOT so they all see the same data
+	SingleTransaction bool
+	// Prepared runs every query as a server-side prepared statement, even
+	// without any arguments, instead of as text. Scripts are split into
+	// their statements since each is prepared on its own.
+	Prepared bool
+	// AddColumns are added to every result set with the same value in each
+	// row, after the columns of the query or in front of them with
+	// PrependColumns. A result set that already has a column with one of the
+	// names fails.
+	AddColumns     []AddedColumn
+	PrependColumns bool
+	// StrictColumns fails the export when a result set doesn't have the same
+	// column names, in the same order, as the ones before it. Otherwise only
+	// the number of columns is checked, and only when the result sets share
+	// stdout or a file.
+	StrictColumns bool
+	// ShowWarnings logs the warnings MySQL reports for each query, such as
+	// truncated GROUP_CONCAT results. Strict also fails the query when there
+	// are any.
+	ShowWarnings bool
+	Strict       bool
+	// FooterFile writes the footer of each output to a .ctl file next to it
+	// instead of after its last row
+	FooterFile bool
+	// ColumnTypes writes the columns of each result set to a .types.json file
+	// next to its output, or to stderr for stdout
+	ColumnTypes bool
+	// Metadata writes a .meta.json file next to each output with the query,
+	// its parameters, when it ran, the rows, bytes and columns written and
+	// the Version
+	Metadata bool
+	// OnComplete is a shell command run after each output file is closed.
+	// {file}, {rows} and {index} are replaced with the file, its number of
+	// rows and its result set number. A failing command fails the export
+	// unless IgnoreHookErrors is set.
+	OnComplete       string
+	IgnoreHookErrors bool
+	// MaxRowsPerSecond limits how fast rows are read from the database, across
+	// every job, so a large export doesn't overload it. 0 is unlimited.
+	MaxRowsPerSecond int
+	// QueryRetries is how many times a query that fails with a transient
+	// error, like a deadlock, is run again. RetryDelay is the wait before the
+	// first retry and doubles after each one.
+	QueryRetries int
+	RetryDelay   time.Duration
+	// MaxRowsTotal stops Run once this many rows have been exported from all
+	// of the result sets together. The file being written is finished and the
+	// rest of the export is skipped.
+	MaxRowsTotal int
+	// Since is the watermark saved by the previous run. The query only
+	// returns the rows whose WatermarkColumn is greater. The whole query is
+	// exported when it's nil, e.g. on the first run.
+	Since *string
+	// ExpectRows is the number of rows the export must produce, or
+	// ExpectRowsQuery a query returning it that runs after the export. Run
+	// removes the output files and fails when the count is different.
+	ExpectRows      *int
+	ExpectRowsQuery string
+
+	// Hosts runs the queries on each of these servers in turn instead of on
+	// DB. Every host is exported like a Run of its own, normally to the files
+	// of its {host}. MergeHosts instead writes the rows of every host to the
+	// same result set with the SourceHostColumn in front. A host that fails
+	// doesn't stop the others unless FailFast is set, and the failures are
+	// returned together at the end as a HostsError.
+	Hosts      []Host
+	MergeHosts bool
+	FailFast   bool
+
+	// Version is the version of mysql2csv recorded in the comments and
+	// metadata
+	Version string
+
+	// Results has an entry for every result set that has been written
+	Results []Result
+	// Retries counts the queries that were run again after a transient error
+	Retries int
+	// Throttled is how long Run waited to stay under MaxRowsPerSecond
+	Throttled time.Duration
+	// Failures has an entry for every query that failed with KeepGoing
+	Failures []Failure
+	prevCols []string
+	// conn is the connection every query runs on with SingleTransaction
+	conn *sql.Conn
+	// streamed is set once the current query has written to stdout
+	streamed bool
+	// limiter keeps the export under MaxRowsPerSecond
+	limiter *rateLimiter
+	// budget counts the rows left before MaxRowsTotal is reached
+	budget *rowBudget
+	// singleResultSet is set on the exporters used by parallel exports since
+	// a second result set would reuse the file number of another query
+	singleResultSet bool
+	// hostFailures are the hosts MergeHosts skipped
+	hostFailures []HostFailure
+}
+
+// Query is a single unit of work for the Exporter
+type Query struct {
+	SQL  string
+	Args []any
+	// Table is set when the query exports a whole table
+	Table string
+	// Database is the database the query runs in instead of the default of
+	// the connection. It also fills in the {database} of the output template.
+	Database string
+}
+
+// Result describes a result set that was written. File is empty if the
+// result set was written to stdout or skipped because it was empty.
+type Result struct {
+	Query Query
+	// Index is the file number used for the result set
+	Index   int
+	File    string
+	Rows    int
+	Bytes   int64
+	Columns []ColumnSchema
+	// FirstKey and LastKey are the first and last values of the key column
+	FirstKey, LastKey *string
+	// Sanitized is the number of values changed by SafeExcelPrefix
+	Sanitized int
+	// BlobFiles and BlobBytes count the files written to the BlobDir for the
+	// result set and their size
+	BlobFiles int
+	BlobBytes int64
+	// Watermark is the largest value of the WatermarkColumn, or nil if there
+	// were no rows
+	Watermark *string
+	// Partition is the value of the PartitionBy column of the rows in File
+	Partition string
+	// Started and Finished are when the query started returning the result
+	// set and when its output was closed
+	Started, Finished time.Time
+	// partitions are the files the result set was split into by PartitionBy
+	partitions []Result
+	// outputName is the name File had before FileRename picked a free one
+	outputName string
+}
+
+// Failure is a query that failed when KeepGoing was set. File is the output
+// the query would have been written to, if it returns rows.
+type Failure struct {
+	Query Query
+	File  string
+	Err   error
+}
+
+// FailuresError is returned when some of the queries of a KeepGoing export
+// failed. It lists every failure.
+type FailuresError struct {
+	Failures []Failure
+	Total    int
+}
+
+func (e *FailuresError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d of %d queries failed", len(e.Failures), e.Total)
+	for _, f := range e.Failures {
+		b.WriteString("\n  ")
+		if f.File != "" {
+			b.WriteString(f.File + ": ")
+		}
+		b.WriteString(f.Err.Error())
+	}
+	return b.String()
+}
+
+func (e *FailuresError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = f.Err
+	}
+	return errs
+}
+
+// QueryError is returned when the database rejects a query
+type QueryError struct {
+	Query string
+	DSN   string
+	Err   error
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("Error executing query (%s) on (%s): %s", e.Query, e.DSN, e.Err)
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+// Validate checks the Output and WriteOptions and the options of the export
+// that can't be used together. The errors name the command line flags of the
+// options.
+func (e *Exporter) Validate() (err error) {
+	if err = e.Output.Validate(); err != nil {
+		return
+	}
+	if err = e.WriteOptions.Validate(); err != nil {
+		return
+	}
+	output := e.Output.OutputTemplate
+	if e.HeaderOnly && e.PaginateColumn != "" {
+		return fmt.Errorf("--header-only can't be used with --no-header or --paginate-column")
+	}
+	if e.Footer != "" {
+		if e.FooterFile {
+			if output == "" {
+				return fmt.Errorf("--footer-file needs an --output to write the .ctl file next to")
+			}
+		} else if e.Format == FormatJSON || e.Format == FormatNDJSON {
+			return fmt.Errorf("--footer can only be written after the rows with the csv, tsv, table and vertical formats, use --footer-file with json and ndjson")
+		}
+	}
+	if e.MaxRowsTotal < 0 {
+		return fmt.Errorf("--max-rows-total can't be negative")
+	}
+	if e.MaxRowsPerSecond < 0 {
+		return fmt.Errorf("--max-rows-per-second can't be negative")
+	}
+	if e.QueryRetries < 0 {
+		return fmt.Errorf("--query-retries can't be negative")
+	}
+	if e.QueryRetries > 0 && (e.SingleTransaction || e.MaxRowsTotal > 0) {
+		return fmt.Errorf("--query-retries can't be used with --single-transaction or --max-rows-total")
+	}
+	if e.Sample > 0 && (e.PaginateColumn != "" || e.KeyColumn != "") {
+		return fmt.Errorf("--sample can't be used with --paginate-column or --manifest-key")
+	}
+	if e.PartitionBy != "" {
+		if !OutputHasPartition(output) {
+			return fmt.Errorf("--partition-by needs an --output with %%s where the value of the column goes, e.g. -o \"region-%%s.csv\"")
+		}
+		if e.FooterFile || e.Output.Tee {
+			return fmt.Errorf("--partition-by can't be used with --sample, --header-only, --blob-dir, --footer-file or --tee")
+		}
+	} else if OutputHasPartition(output) {
+		return fmt.Errorf("%%s in the output template is the value of the --partition-by column, use %%%% for a literal %%")
+	}
+	if e.Metadata && output == "" {
+		return fmt.Errorf("--metadata needs an --output to write the .meta.json files next to")
+	}
+	if e.ExpectRows != nil && *e.ExpectRows < 0 {
+		return fmt.Errorf("--expect-rows can't be negative")
+	}
+	if (e.ExpectRows != nil || e.ExpectRowsQuery != "") && (e.MaxRowsTotal > 0 || e.Sample > 0 || e.HeaderOnly) {
+		return fmt.Errorf("--expect-rows can't be used with --max-rows-total, --sample or --header-only since they change how many rows are written")
+	}
+	if e.WatermarkColumn != "" && (e.PaginateColumn != "" || e.Sample > 0 || e.MaxRowsTotal > 0 || e.HeaderOnly || e.Jobs > 1) {
+		return fmt.Errorf("--watermark-file can't be used with --paginate-column, --sample, --max-rows-total, --header-only or --jobs")
+	}
+	if e.PaginateColumn != "" {
+		if e.PageSize <= 0 {
+			return fmt.Errorf("--page-size must be greater than 0")
+		}
+		if !OutputCreatesMultipleFiles(output) {
+			return fmt.Errorf("--paginate-column requires an output template that creates a file for each page, such as -o output-%%03d.csv")
+		}
+	}
+	if e.MergeHosts {
+		if OutputHasHost(output) {
+			return fmt.Errorf("--merge-hosts writes every host to the same output so --output can't contain {host}")
+		}
+		if e.SingleTransaction || e.Prepared || e.ShowWarnings || e.Strict || e.Jobs > 1 || e.PaginateColumn != "" {
+			return fmt.Errorf("--merge-hosts can't be used with --single-transaction, --prepared, --show-warnings, --strict, --jobs or --paginate-column")
+		}
+	}
+	if e.Jobs > 1 {
+		if e.StrictColumns {
+			return fmt.Errorf("--strict-columns can't be used with --jobs since the result sets are written in parallel")
+		}
+		if e.SingleTransaction {
+			return fmt.Errorf("--single-transaction can't be used with --jobs since the snapshot belongs to a single connection")
+		}
+		if e.Output.Tee {
+			return fmt.Errorf("--tee can't be used with --jobs since the rows of the parallel queries would be mixed together on stdout")
+		}
+	}
+	return
+}
+
+// Run exports every query. Scripts are split into their statements when
+// running more than one job, with KeepGoing or with Prepared so each one can
+// be run and fail on its own.
+func (e *Exporter) Run(ctx context.Context) (err error) {
+	if len(e.Hosts) > 0 && !e.MergeHosts {
+		return e.runHosts(ctx)
+	}
+	if e.MergeHosts && (e.SingleTransaction || e.Prepared || e.ShowWarnings || e.Strict || e.Jobs > 1 || e.PaginateColumn != "" || e.WatermarkColumn != "") {
+		return fmt.Errorf("The rows of several hosts can't be merged with a single transaction, prepared statements, warnings, more than one job, pagination or a watermark")
+	}
+	if e.DB == nil && !e.MergeHosts {
+		if e.DB, err = sql.Open("mysql", e.DSN); err != nil {
+			return fmt.Errorf("Error connecting to database (%s): %w", e.MaskedDSN, err)
+		}
+		defer func() {
+			e.DB.Close()
+			e.DB = nil
+		}()
+	}
+	var queries []Query
+	for _, query := range e.Queries {
+		// MySQL stops running a script at the first error. A prepared
+		// statement can only hold one statement.
+		if e.Jobs <= 1 && !e.KeepGoing && !e.Prepared && !e.MergeHosts {
+			queries = append(queries, query)
+			continue
+		}
+		for _, stmt := range SplitStatements(query.SQL) {
+			q := query
+			q.SQL = stmt
+			queries = append(queries, q)
+		}
+	}
+	// The exporters of Hosts share the budget of the whole export
+	if e.budget == nil {
+		e.budget = newRowBudget(e.MaxRowsTotal)
+		defer func() {
+			if err == nil {
+				e.logBudgetReached()
+			}
+			e.budget = nil
+		}()
+	}
+	if e.limiter = newRateLimiter(ctx, e.MaxRowsPerSecond); e.limiter != nil {
+		slog.Info(fmt.Sprintf("reading at most %d rows per second", e.MaxRowsPerSecond), "max_rows_per_second", e.MaxRowsPerSecond)
+		defer func() {
+			e.Throttled += e.limiter.throttled()
+			e.limiter = nil
+		}()
+	}
+	if e.MergeHosts {
+		for _, query := range queries {
+			if !isSingleStatement(query.SQL) || !returnsRows(query.SQL) {
+				return fmt.Errorf("Only SELECT statements can be merged across hosts (%s)", query.SQL)
+			}
+		}
+	}
+	if e.HeaderOnly {
+		for i := range queries {
+			queries[i].SQL = HeaderOnlySQL(queries[i].SQL)
+		}
+	}
+	if e.WatermarkColumn != "" {
+		if len(queries) != 1 || !isSingleStatement(queries[0].SQL) || !returnsRows(queries[0].SQL) {
+			return fmt.Errorf("A watermark can only be used with a single SELECT statement")
+		}
+		if e.Since != nil {
+			queries[0] = sinceQuery(queries[0], e.WatermarkColumn, *e.Since)
+		}
+	}
+	if e.SingleTransaction {
+		if e.Jobs > 1 {
+			return fmt.Errorf("A single transaction can't be used with more than one job since the snapshot belongs to one connection")
+		}
+		var end func(error) error
+		if end, err = e.startSnapshot(ctx); err != nil {
+			return
+		}
+		defer func() { err = end(err) }()
+	}
+	if e.PaginateColumn != "" {
+		if len(queries) != 1 {
+			return fmt.Errorf("Only a single query can be paginated")
+		}
+		err = e.ExportPages(ctx, queries[0], e.PaginateColumn, e.PageSize)
+	} else {
+		err = e.ExportAll(ctx, queries, e.Jobs)
+	}
+	if err != nil {
+		return
+	}
+	if err = e.hostsError(); err != nil {
+		return
+	}
+	// Still inside the snapshot so the query counts the rows that were
+	// exported
+	return e.verifyRows(ctx)
+}
+
+// logBudgetReached logs that the export stopped at MaxRowsTotal, if it did
+func (e *Exporter) logBudgetReached() {
+	if e.budget.wasReached() {
+		slog.Info(fmt.Sprintf("stopped after exporting %d rows, the most allowed in total", e.MaxRowsTotal), "max_rows_total", e.MaxRowsTotal)
+	}
+}
+
+// ExportAll exports each query in order, or up to jobs queries at a time when
+// jobs is more than 1. Parallel queries are numbered in the order they were
+// given. The first error cancels the queries that are still running and every
+// error that caused a failure is returned. With KeepGoing every query runs and
+// the failures are returned together as a FailuresError.
+func (e *Exporter) ExportAll(ctx context.Context, queries []Query, jobs int) (err error) {
+	if jobs <= 1 {
+		for _, query := range queries {
+			if e.budget.spent() {
+				break
+			}
+			if err = e.Export(ctx, query); err != nil {
+				if !e.KeepGoing || ctx.Err() != nil {
+					return
+				}
+				e.failed(query, err)
+				// The failed query uses up its file number so the files
+				// of the queries after it are numbered the same as when
+				// it succeeds
+				if returnsRows(query.SQL) {
+					e.Output.FileNum++
+				}
+			}
+		}
+		return e.failures(len(queries))
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	exporters := make([]*Exporter, len(queries))
+	errs := make([]error, len(queries))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for range min(jobs, len(queries)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				if e.budget.spent() {
+					continue
+				}
+				sub := *e
+				sub.Results = nil
+				sub.prevCols = nil
+				sub.singleResultSet = true
+				sub.Output.FileNum = e.Output.FileNum + i
+				if errs[i] = sub.Export(ctx, queries[i]); errs[i] != nil && !e.KeepGoing {
+					cancel()
+				}
+				exporters[i] = &sub
+			}
+		}()
+	}
+	for i := range queries {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	// Only report the cancellations caused by an interrupt, not the ones
+	// caused by another query failing
+	if err = parent.Err(); err != nil {
+		return
+	}
+	var failed []error
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, context.Canceled) {
+			failed = append(failed, err)
+		}
+	}
+
+	for i, sub := range exporters {
+		if sub == nil {
+			continue
+		}
+		e.Results = append(e.Results, sub.Results...)
+		e.Retries += sub.Retries
+		if errs[i] != nil && e.KeepGoing {
+			sub.failed(queries[i], errs[i])
+			e.Failures = append(e.Failures, sub.Failures...)
+		}
+	}
+	e.Output.FileNum += len(queries)
+	if e.KeepGoing {
+		return e.failures(len(queries))
+	}
+	return errors.Join(failed...)
+}
+
+// failed records a query that failed with KeepGoing
+func (e *Exporter) failed(query Query, err error) {
+	f := Failure{Query: query, Err: err}
+	if returnsRows(query.SQL) {
+		f.File = outputFilename(e.Output)
+	}
+	e.Failures = append(e.Failures, f)
+}
+
+// failures returns a FailuresError if any of the queries failed
+func (e *Exporter) failures(total int) error {
+	if len(e.Failures) == 0 {
+		return nil
+	}
+	return &FailuresError{Failures: e.Failures, Total: total}
+}
+
+// ExportPages exports the query one page at a time using keyset pagination
+// on the column, which must be unique and not null. Each page is written as
+// its own result set and the export stops once a page comes back short.
+func (e *Exporter) ExportPages(ctx context.Context, query Query, column string, pageSize int) (err error) {
+	if !isSingleStatement(query.SQL) {
+		return fmt.Errorf("Only a single SELECT statement can be paginated")
+	}
+	if e.KeyColumn != "" && e.KeyColumn != column {
+		return fmt.Errorf("The key column must be the same as the pagination column")
+	}
+	e.KeyColumn = column
+	// The last page is empty when the row count is a multiple of the page
+	// size so it shouldn't produce a file
+	defer func(skip bool) { e.SkipEmptyResultSets = skip }(e.SkipEmptyResultSets)
+	inner := strings.TrimRight(strings.TrimSpace(query.SQL), ";")
+	col := quoteIdentifier(column)
+	var lastKey *string
+	for {
+		page := query
+		page.SQL = fmt.Sprintf("SELECT * FROM (%s) AS mysql2csv_page ORDER BY %s LIMIT %d", inner, col, pageSize)
+		if lastKey != nil {
+			page.SQL = fmt.Sprintf("SELECT * FROM (%s) AS mysql2csv_page WHERE %s > ? ORDER BY %s LIMIT %d", inner, col, col, pageSize)
+			page.Args = append(append([]any{}, query.Args...), *lastKey)
+		}
+		if err = e.Export(ctx, page); err != nil {
+			return
+		}
+		res := e.Results[len(e.Results)-1]
+		if res.Rows < pageSize {
+			return
+		}
+		if res.LastKey == nil {
+			return fmt.Errorf("The pagination column %s is not in the result set", column)
+		}
+		if e.budget.spent() {
+			return
+		}
+		lastKey = res.LastKey
+		e.SkipEmptyResultSets = true
+	}
+}
+
+// Export executes the query and writes every result set it returns. The query
+// runs on a connection of its own, or the snapshot's with SingleTransaction,
+// so the warnings checked afterwards are the ones it produced.
+//
+// A query that fails with a deadlock, lock wait timeout or lost connection is
+// run again up to QueryRetries times. The result sets it had already written
+// are written again from the start so no rows are duplicated.
+func (e *Exporter) Export(ctx context.Context, query Query) (err error) {
+	if e.ReadOnly {
+		warnUnlessReadOnly(query.SQL)
+	}
+	// Set before running the query so a failure is reported with the file
+	// of its database
+	if query.Database != "" {
+		e.Output.Database = query.Database
+	}
+	results, fileNum, prevCols := len(e.Results), e.Output.FileNum, e.prevCols
+	for attempt := 0; ; attempt++ {
+		e.streamed = false
+		if err = e.exportOnce(ctx, query); err == nil && attempt > 0 {
+			slog.Info(fmt.Sprintf("the query succeeded after %d retries", attempt), "query", query.SQL, "retries", attempt)
+		}
+		if err == nil || !e.canRetry(err) {
+			return
+		}
+		if attempt == e.QueryRetries {
+			return fmt.Errorf("%w (gave up after %d retries)", err, attempt)
+		}
+		delay := e.RetryDelay << attempt
+		slog.Warn(fmt.Sprintf("retrying the query in %s after a transient error (retry %d of %d): %s", delay, attempt+1, e.QueryRetries, err), "query", query.SQL, "retry", attempt+1, "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		// Forget the result sets of the failed attempt so their files are
+		// written again instead of appended to, or renamed with FileRename
+		for _, r := range e.Results[results:] {
+			if isLocalFile(r.File) {
+				os.Remove(r.File)
+			}
+		}
+		e.Results, e.Output.FileNum, e.prevCols = e.Results[:results], fileNum, prevCols
+		e.Retries++
+	}
+}
+
+// canRetry reports whether the query can run again after failing with err.
+// Rows already written to stdout can't be taken back and the snapshot of a
+// single transaction is lost when the server rolls it back.
+func (e *Exporter) canRetry(err error) bool {
+	return e.QueryRetries > 0 && isRetryable(err) && e.conn == nil && e.budget == nil && !e.streamed
+}
+
+// exportOnce runs the query a single time
+func (e *Exporter) exportOnce(ctx context.Context, query Query) (err error) {
+	if e.MergeHosts {
+		return e.exportMerged(ctx, query)
+	}
+	conn := e.conn
+	if conn == nil {
+		if conn, err = e.connect(ctx); err != nil {
+			return
+		}
+		defer conn.Close()
+	}
+	if err = useDatabase(ctx, conn, query, e.MaskedDSN); err != nil {
+		return
+	}
+	if err = e.export(ctx, conn, query); err != nil {
+		return
+	}
+	if e.ShowWarnings || e.Strict {
+		return e.checkWarnings(ctx, conn, query)
+	}
+	return
+}
+
+// connect takes a connection from the pool and sets up its session
+func (e *Exporter) connect(ctx context.Context) (conn *sql.Conn, err error) {
+	return e.openConn(ctx, e.DB, e.MaskedDSN)
+}
+
+// openConn takes a connection from the pool of db, which dsn identifies in
+// errors, and sets up its session
+func (e *Exporter) openConn(ctx context.Context, db *sql.DB, dsn string) (conn *sql.Conn, err error) {
+	if conn, err = db.Conn(ctx); err != nil {
+		return nil, fmt.Errorf("Error connecting to database (%s): %w", dsn, err)
+	}
+	defer func() {
+		if err != nil {
+			conn.Close()
+		}
+	}()
+	for i, cmd := range e.InitCommands {
+		if _, err = conn.ExecContext(ctx, cmd); err != nil {
+			return nil, &QueryError{Query: cmd, DSN: dsn, Err: fmt.Errorf("init command %d: %w", i+1, err)}
+		}
+	}
+	if e.ReadOnly {
+		if _, err = conn.ExecContext(ctx, readOnlySQL); err != nil {
+			return nil, &QueryError{Query: readOnlySQL, DSN: dsn, Err: err}
+		}
+	}
+	return conn, nil
+}
+
+// startSnapshot pins a connection and starts the transaction every query runs
+// in with SingleTransaction. The returned function ends it, committing if the
+// export succeeded and rolling back otherwise.
+func (e *Exporter) startSnapshot(ctx context.Context) (end func(err error) error, err error) {
+	if e.conn, err = e.connect(ctx); err != nil {
+		return
+	}
+	if _, err = e.conn.ExecContext(ctx, snapshotSQL); err != nil {
+		e.conn.Close()
+		e.conn = nil
+		return nil, &QueryError{Query: snapshotSQL, DSN: e.MaskedDSN, Err: err}
+	}
+	return func(err error) error {
+		defer func() {
+			e.conn.Close()
+			e.conn = nil
+		}()
+		if err != nil {
+			e.conn.ExecContext(context.Background(), "ROLLBACK")
+			return err
+		}
+		if _, err = e.conn.ExecContext(ctx, "COMMIT"); err != nil {
+			return &QueryError{Query: "COMMIT", DSN: e.MaskedDSN, Err: err}
+		}
+		return nil
+	}, nil
+}
+
+func (e *Exporter) export(ctx context.Context, conn *sql.Conn, query Query) (err error) {
+	var stmt *sql.Stmt
+	if e.Prepared {
+		if stmt, err = conn.PrepareContext(ctx, query.SQL); err != nil {
+			return &QueryError{Query: query.SQL, DSN: e.MaskedDSN, Err: fmt.Errorf("preparing the statement: %w", err)}
+		}
+		// Deferred first so it's closed after the rows
+		defer stmt.Close()
+	}
+	if isSingleStatement(query.SQL) && !returnsRows(query.SQL) {
+		var res sql.Result
+		if stmt != nil {
+			res, err = stmt.ExecContext(ctx, query.Args...)
+		} else {
+			res, err = conn.ExecContext(ctx, query.SQL, query.Args...)
+		}
+		if err != nil {
+			return &QueryError{Query: query.SQL, DSN: e.MaskedDSN, Err: err}
+		}
+		if affected, err := res.RowsAffected(); err == nil {
+			slog.Info(fmt.Sprintf("Query OK, %d rows affected", affected), "rows_affected", affected)
+		}
+		return nil
+	}
+
+	// Asked before the query since the connection is busy until its rows
+	// have been read
+	var server string
+	if e.CommentPrefix != "" {
+		server = serverHostname(ctx, conn)
+	}
+	addedTypes, err := addedColumnTypes(ctx, conn, e.AddColumns)
+	if err != nil {
+		return &QueryError{Query: query.SQL, DSN: e.MaskedDSN, Err: err}
+	}
+	var rows *sql.Rows
+	if stmt != nil {
+		rows, err = stmt.QueryContext(ctx, query.Args...)
+	} else {
+		rows, err = conn.QueryContext(ctx, query.SQL, query.Args...)
+	}
+	if err != nil {
+		return &QueryError{Query: query.SQL, DSN: e.MaskedDSN, Err: err}
+	}
+	defer func() {
+		if cerr := rows.Close(); err == nil && cerr != nil {
+			err = &QueryError{Query: query.SQL, DSN: e.MaskedDSN, Err: cerr}
+		}
+	}()
+	return e.writeResultSets(ctx, query, e.withAddedColumns(rows, addedTypes), server)
+}
+
+// writeResultSets writes every result set of the rows of the query. server is
+// the hostname recorded in the comment.
+func (e *Exporter) writeResultSets(ctx context.Context, query Query, rows resultRows, server string) (err error) {
+	hasResultSet := true
+	for hasResultSet {
+		cols, err := rows.Columns()
+		if err != nil {
+			return err
+		}
+		// The driver skips statements without a result set when there are
+		// more statements to come, so this only happens when none of them
+		// returned one
+		if len(cols) == 0 {
+			slog.Info("Query OK, no result set returned")
+			break
+		}
+		if len(cols) != len(e.prevCols) && len(e.prevCols) > 0 && !e.createsMultipleFiles(query) && !e.HeaderOnly && !e.Profile {
+			return fmt.Errorf("The number of columns in each result set must be the same when writing to stdout or a valid output template must be provided")
+		}
+		if e.StrictColumns && len(e.prevCols) > 0 && !slices.Equal(cols, e.prevCols) {
+			return fmt.Errorf("The columns of result set %d (%s) are different from the columns of the result set before it (%s)", e.Output.FileNum, strings.Join(cols, ", "), strings.Join(e.prevCols, ", "))
+		}
+		e.prevCols = cols
+		types, err := rows.ColumnTypes()
+		if err != nil {
+			return err
+		}
+		e.Output.Table = query.Table
+		e.Output.Query = query.SQL
+		opts := e.WriteOptions
+		opts.SkipEmpty = e.SkipEmptyResultSets
+		opts.budget = e.budget
+		opts.limiter = e.limiter
+		if opts.CommentPrefix != "" {
+			opts.comment = e.comment(query, server)
+		}
+		if e.Output.EncodingErrors == EncodingErrorsFail {
+			opts.encoding = e.Output.Encoding
+		}
+		// file is where the result set was written, which the sidecar files
+		// are named after
+		var file string
+		if opts.BlobDir != "" && len(opts.BlobColumns) > 0 {
+			dir, blobColumns, key, index := opts.BlobDir, opts.BlobColumns, opts.BlobKey, e.Output.FileNum
+			opts.newBlobs = func(columns []string) (*blobWriter, error) {
+				return newBlobWriter(dir, file, index, columns, blobColumns, key)
+			}
+		}
+		if opts.Footer != "" && e.FooterFile {
+			output := e.Output
+			opts.openFooter = func() (io.WriteCloser, error) {
+				return openDestination(output, file+".ctl")
+			}
+		}
+
+		if e.HeaderOnly && e.Output.OutputTemplate == "" && len(e.Results) > 0 {
+			// Separate the headers of each result set on stdout
+			fmt.Fprintln(e.Output.stdout())
+		}
+
+		var openErr error
+		var written int64
+		opened := false
+		// The files and sizes of each partition of the result set, by name,
+		// and the names they had before FileRename
+		partFiles := map[string]string{}
+		partNames := map[string]string{}
+		partWritten := map[string]*int64{}
+		if opts.PartitionBy != "" {
+			opts.openPartition = func(name string) (output io.WriteCloser, err error) {
+				data := e.Output
+				data.Partition = name
+				file := outputFilename(data)
+				for other, f := range partNames {
+					if f == file {
+						openErr = fmt.Errorf("the partitions %s and %s would both be written to %s", other, name, file)
+						return nil, openErr
+					}
+				}
+				if e.wroteFile(file) {
+					openErr = fmt.Errorf("partition %s of result set %d would overwrite %s, which an earlier result set was written to. Add %%d to the output template to write each result set to its own files", name, e.Output.FileNum, file)
+					return nil, openErr
+				}
+				partWritten[name] = new(int64)
+				partNames[name] = file
+				output, partFiles[name], openErr = getOutput(data, partWritten[name])
+				return output, openErr
+			}
+		}
+		open := func() (output io.WriteCloser, err error) {
+			if file := outputFilename(e.Output); file != "" && e.wroteFile(file) {
+				return nil, fmt.Errorf("result set %d would overwrite %s, which an earlier result set was written to. Add %%d to the output template to write each result set to its own file", e.Output.FileNum, file)
+			}
+			output, file, openErr = getOutput(e.Output, &written)
+			opened = openErr == nil
+			if opened && (outputFilename(e.Output) == "" || e.Output.Tee) {
+				e.streamed = true
+			}
+			return output, openErr
+		}
+		started := time.Now()
+		result, err := writeResultSet(rows, open, opts)
+		if openErr != nil {
+			return &OutputError{Query: query.SQL, Err: fmt.Errorf("Error getting output: %w", openErr)}
+		}
+		if err != nil {
+			var outputErr *OutputError
+			if errors.As(err, &outputErr) {
+				outputErr.Query = query.SQL
+				// Outputs that fail to close, e.g. because the disk filled up
+				// while the last rows were flushed, aren't aborted
+				removePartial(file)
+				for _, f := range partFiles {
+					removePartial(f)
+				}
+			}
+			return fmt.Errorf("Error writing result set: %w", err)
+		}
+		result.Bytes = written
+		if opened {
+			result.File, result.outputName = file, outputFilename(e.Output)
+		}
+		results := []Result{result}
+		if len(result.partitions) > 0 {
+			// Each partition is reported as a file of its own. The totals of
+			// the result set go with the first one.
+			results = result.partitions
+			results[0].Sanitized, results[0].Watermark = result.Sanitized, result.Watermark
+			for i := range results {
+				results[i].File = partFiles[results[i].Partition]
+				results[i].outputName = partNames[results[i].Partition]
+				results[i].Bytes = *partWritten[results[i].Partition]
+			}
+		}
+		finished := time.Now()
+		for _, result := range results {
+			result.Query = query
+			result.Index = e.Output.FileNum
+			result.Columns = columnSchemas(types)
+			result.Started, result.Finished = started, finished
+			e.Results = append(e.Results, result)
+			if e.ColumnTypes {
+				if err := writeColumnTypes(e.Output, result); err != nil {
+					return &OutputError{Query: query.SQL, Err: fmt.Errorf("Error writing column types: %w", err)}
+				}
+			}
+			if e.Metadata {
+				if err := writeMetadata(e.Output, result, e.Version); err != nil {
+					return &OutputError{Query: query.SQL, Err: fmt.Errorf("Error writing metadata: %w", err)}
+				}
+			}
+			if err := e.runHook(ctx, result); err != nil {
+				return err
+			}
+			slog.Debug("wrote result set", "result_set", result.Index, "file", result.File, "partition", result.Partition, "rows", result.Rows, "bytes", result.Bytes, "duration_ms", time.Since(started).Milliseconds())
+		}
+		hasResultSet = rows.NextResultSet()
+		if hasResultSet && e.budget.spent() {
+			e.Output.FileNum++
+			break
+		}
+		if hasResultSet && e.singleResultSet {
+			return fmt.Errorf("Queries exported in parallel must return a single result set (%s)", query.SQL)
+		}
+		e.Output.FileNum++
+	}
+	// NextResultSet also returns false when a later statement in the query
+	// fails. The merged rows of several hosts say which one failed.
+	if err = rows.Err(); err != nil {
+		var queryErr *QueryError
+		if errors.As(err, &queryErr) {
+			return err
+		}
+		return &QueryError{Query: query.SQL, DSN: e.MaskedDSN, Err: err}
+	}
+	return
+}
+
+// removePartial removes a local output file that failed to be written
+func removePartial(file string) {
+	if isLocalFile(file) {
+		os.Remove(file)
+	}
+}
+
+// checkWarnings logs the warnings MySQL reported for the query. Only the
+// warnings of the last statement are kept by the server. With Strict any
+// warning fails the query.
+func (e *Exporter) checkWarnings(ctx context.Context, conn *sql.Conn, query Query) error {
+	rows, err := conn.QueryContext(ctx, "SHOW WARNINGS")
+	if err != nil {
+		return &QueryError{Query: query.SQL, DSN: e.MaskedDSN, Err: fmt.Errorf("showing warnings: %w", err)}
+	}
+	defer rows.Close()
+	count := 0
+	for rows.Next() {
+		var level, message string
+		var code int
+		if err = rows.Scan(&level, &code, &message); err != nil {
+			return &QueryError{Query: query.SQL, DSN: e.MaskedDSN, Err: fmt.Errorf("showing warnings: %w", err)}
+		}
+		slog.Warn(fmt.Sprintf("MySQL %s %d: %s", strings.ToLower(level), code, message), "level", level, "code", code, "message", message, "query", query.SQL)
+		count++
+	}
+	if err = rows.Err(); err != nil {
+		return &QueryError{Query: query.SQL, DSN: e.MaskedDSN, Err: fmt.Errorf("showing warnings: %w", err)}
+	}
+	if e.Strict && count > 0 {
+		return &QueryError{Query: query.SQL, DSN: e.MaskedDSN, Err: fmt.Errorf("the query produced %d warnings", count)}
+	}
+	return nil
+}
+
+// createsMultipleFiles reports whether the result sets of the query are
+// written to files of their own, which the {database} of a query with a
+// Database also does
+func (e *Exporter) createsMultipleFiles(query Query) bool {
+	return OutputCreatesMultipleFiles(e.Output.OutputTemplate) || query.Database != "" && OutputHasDatabase(e.Output.OutputTemplate)
+}
+
+// wroteFile reports whether an earlier result set was written to the file,
+// or would have been without FileRename
+func (e *Exporter) wroteFile(file string) bool {
+	for _, r := range e.Results {
+		if r.File == file || r.outputName == file {
+			return true
+		}
+	}
+	return false
+}
+
+// comment returns the comment written before the header of each result set
+// of the query. The query is collapsed onto one line.
+func (e *Exporter) comment(query Query, server string) string {
+	exported := e.Output.Started
+	if exported.IsZero() {
+		exported = time.Now()
+	}
+	comment := fmt.Sprintf("exported %s", exported.UTC().Format(time.RFC3339))
+	if e.MaskedDSN != "" {
+		comment += " from " + e.MaskedDSN
+	}
+	if server != "" {
+		comment += " (server " + server + ")"
+	}
+	if e.Version != "" {
+		comment += " by mysql2csv " + e.Version
+	}
+	return comment + ": " + strings.Join(strings.Fields(query.SQL), " ")
+}
+
+// serverHostname returns the hostname the server reports, which tells the
+// replicas behind one address apart. It's empty if the server doesn't say.
+func serverHostname(ctx context.Context, conn *sql.Conn) (hostname string) {
+	if err := conn.QueryRowContext(ctx, "SELECT @@hostname").Scan(&hostname); err != nil {
+		slog.Debug(fmt.Sprintf("couldn't get the server's hostname: %s", err), "error", err)
+		return ""
+	}
+	return
+}
+
+// LogTableSummary logs the number of rows exported from each table
+func (e *Exporter) LogTableSummary() {
+	for _, r := range e.Results {
+		if r.Query.Table == "" {
+			continue
+		}
+		if r.Query.Database != "" {
+			slog.Info(fmt.Sprintf("%s.%s: %d rows", r.Query.Database, r.Query.Table, r.Rows), "database", r.Query.Database, "table", r.Query.Table, "rows", r.Rows)
+		} else {
+			slog.Info(fmt.Sprintf("%s: %d rows", r.Query.Table, r.Rows), "table", r.Query.Table, "rows", r.Rows)
+		}
+	}
+}
+
+// LogEmptySummary logs the result sets that were skipped because they didn't
+// have any rows
+func (e *Exporter) LogEmptySummary() {
+	for _, r := range e.Results {
+		if r.File != "" || r.Rows > 0 {
+			continue
+		}
+		if r.Query.Table != "" {
+			slog.Info(fmt.Sprintf("skipped empty result set %d (%s)", r.Index, r.Query.Table), "result_set", r.Index, "table", r.Query.Table)
+		} else {
+			slog.Info(fmt.Sprintf("skipped empty result set %d", r.Index), "result_set", r.Index)
+		}
+	}
+}
+
+// Watermark returns the largest value of the WatermarkColumn that was
+// exported, or nil if no rows were
+func (e *Exporter) Watermark() *string {
+	for _, r := range e.Results {
+		if r.Watermark != nil {
+			return r.Watermark
+		}
+	}
+	return nil
+}
+
+// LogSanitizedSummary logs how many values were changed by SafeExcelPrefix
+// in each file and in total
+func (e *Exporter) LogSanitizedSummary() {
+	total := 0
+	for _, r := range e.Results {
+		total += r.Sanitized
+		if r.Sanitized > 0 {
+			slog.Info(fmt.Sprintf("sanitized %d cells in result set %d", r.Sanitized, r.Index), "result_set", r.Index, "file", r.File, "sanitized", r.Sanitized)
+		}
+	}
+	slog.Info(fmt.Sprintf("sanitized %d cells that would have been read as formulas", total), "sanitized", total)
+}
+
+// LogStats logs the totals for the export: rows, files and bytes written,
+// the elapsed time and the average rows per second
+func (e *Exporter) LogStats(elapsed time.Duration) {
+	rows, files, blobFiles := 0, 0, 0
+	var bytes, blobBytes int64
+	for _, r := range e.Results {
+		rows += r.Rows
+		bytes += r.Bytes
+		blobFiles += r.BlobFiles
+		blobBytes += r.BlobBytes
+		if r.File != "" {
+			files++
+		}
+	}
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(rows) / elapsed.Seconds()
+	}
+	elapsed = elapsed.Round(time.Millisecond)
+	extra := ""
+	if e.Retries > 0 {
+		extra = fmt.Sprintf(", %d retries", e.Retries)
+	}
+	if blobFiles > 0 {
+		extra += fmt.Sprintf(", %s in %d blob files", formatBytes(blobBytes), blobFiles)
+	}
+	if e.Throttled > 0 {
+		extra += fmt.Sprintf(", throttled for %s", e.Throttled.Round(time.Millisecond))
+	}
+	slog.Info(fmt.Sprintf("exported %d rows to %d files (%s) in %s, %.0f rows/s%s", rows, files, formatBytes(bytes), elapsed, rate, extra),
+		"rows", rows, "files", files, "bytes", bytes, "duration_ms", elapsed.Milliseconds(), "rows_per_second", rate, "retries", e.Retries, "throttled_ms", e.Throttled.Milliseconds(), "blob_files", blobFiles, "blob_bytes", blobBytes)
+}
+
+// formatBytes formats n with the largest binary unit that keeps it above 1
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// snapshotSQL starts the transaction used by SingleTransaction. Every query
+// in it sees the data as it was when it started.
+const snapshotSQL = "START TRANSACTION WITH CONSISTENT SNAPSHOT"
+
+// readOnlySQL makes the server reject writes on the connection
+const readOnlySQL = "SET SESSION TRANSACTION READ ONLY"
+
+// readOnlyKeywords are the statements expected in a read-only export. SET and
+// USE only change the session.
+var readOnlyKeywords = []string{"SELECT", "WITH", "TABLE", "VALUES", "SHOW", "CALL", "DESCRIBE", "DESC", "EXPLAIN", "SET", "USE"}
+
+// warnUnlessReadOnly warns about each statement of the query that doesn't
+// look like it only reads. The server is what enforces ReadOnly, this just
+// points out the statement that's likely to fail.
+func warnUnlessReadOnly(query string) {
+	for _, stmt := range SplitStatements(query) {
+		fields := strings.Fields(stmt)
+		if len(fields) == 0 {
+			continue
+		}
+		keyword := strings.ToUpper(fields[0])
+		if strings.HasPrefix(keyword, "(") || slices.Contains(readOnlyKeywords, keyword) {
+			continue
+		}
+		slog.Warn(fmt.Sprintf("%s statements may fail on a read-only connection: %s", keyword, stmt), "statement", stmt)
+	}
+}
+
+// noResultKeywords are the statements that never return a result set
+var noResultKeywords = []string{
+	"INSERT", "UPDATE", "DELETE", "REPLACE", "CREATE", "ALTER", "DROP", "TRUNCATE", "RENAME",
+	"SET", "USE", "GRANT", "REVOKE", "LOCK", "UNLOCK", "START", "BEGIN", "COMMIT", "ROLLBACK",
+}
+
+// returnsRows reports whether the statement might return a result set. Only
+// the first keyword is checked so anything unrecognized is assumed to return
+// rows.
+func returnsRows(query string) bool {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return true
+	}
+	keyword := strings.ToUpper(strings.TrimRight(fields[0], ";"))
+	for _, k := range noResultKeywords {
+		if keyword == k {
+			return false
+		}
+	}
+	return true
+}
+
+// HeaderOnlySQL gives every SELECT in the script a LIMIT 0 so the columns can
+// be read without fetching any rows. Other statements are left alone since
+// they may set up variables or temporary tables the SELECTs rely on.
+func HeaderOnlySQL(script string) string {
+	statements := SplitStatements(script)
+	for i, stmt := range statements {
+		fields := strings.Fields(stmt)
+		if len(fields) == 0 {
+			continue
+		}
+		switch keyword := strings.ToUpper(fields[0]); {
+		case keyword == "SELECT", keyword == "WITH", keyword == "TABLE", keyword == "VALUES", strings.HasPrefix(keyword, "("):
+			statements[i] = limitZero(stmt)
+		}
+	}
+	return strings.Join(statements, ";\n")
+}
+
+// limitZero replaces the LIMIT of the query with LIMIT 0, or adds one before
+// its locking clause or at the end. The query isn't wrapped in a subquery,
+// which would reject the duplicate column names of a join and hide the
+// tables of the columns. A SELECT ... INTO doesn't return a result set and is
+// left alone.
+func limitZero(query string) string {
+	limit, lock := -1, -1
+	for _, w := range topLevelWords(query) {
+		switch w.word {
+		case "INTO":
+			return query
+		case "LIMIT":
+			limit = w.start
+		case "FOR", "LOCK":
+			if lock < 0 {
+				lock = w.start
+			}
+		}
+	}
+	head, rest := query, ""
+	if lock >= 0 {
+		head, rest = query[:lock], " "+query[lock:]
+	}
+	if limit >= 0 && (lock < 0 || limit < lock) {
+		head = query[:limit]
+	}
+	// The line break keeps a trailing -- comment from commenting out the
+	// LIMIT
+	return strings.TrimRight(head, " \t\n") + "\nLIMIT 0" + rest
+}
+
+// isSingleStatement reports whether SplitStatements finds exactly one
+// statement in the query
+func isSingleStatement(query string) bool {
+	return len(SplitStatements(query)) == 1
+}
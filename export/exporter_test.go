@@ -0,0 +1,137 @@
+package export
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSkipEmptyResultSets(t *testing.T) {
+	empty := testResultSet{columns: []string{"id", "name"}}
+	for _, skip := range []bool{false, true} {
+		dir := t.TempDir()
+		e := &Exporter{
+			DB:                  testDB(t, map[string][]testResultSet{"CALL report()": {numberedRows(2), empty, numberedRows(3)}}),
+			Output:              OutputData{OutputTemplate: filepath.Join(dir, "report-%d.csv")},
+			SkipEmptyResultSets: skip,
+		}
+		if err := e.Export(context.Background(), Query{SQL: "CALL report()"}); err != nil {
+			t.Fatal(err)
+		}
+		// The empty result set only gets a file, with just the header, when
+		// it isn't skipped. It uses up its number either way.
+		want := map[string]string{
+			"report-0.csv": "id,name\n1,name 1\n2,name 2\n",
+			"report-1.csv": "id,name\n",
+			"report-2.csv": "id,name\n1,name 1\n2,name 2\n3,name 3\n",
+		}
+		if skip {
+			delete(want, "report-1.csv")
+		}
+		for name, content := range want {
+			got, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Errorf("skip %t: %s", skip, err)
+				continue
+			}
+			if string(got) != content {
+				t.Errorf("skip %t: got %q in %s, want %q", skip, got, name, content)
+			}
+		}
+		if files, _ := os.ReadDir(dir); len(files) != len(want) {
+			t.Errorf("skip %t: got %d files, want %d", skip, len(files), len(want))
+		}
+		// The summary lists the skipped result set without a file
+		if len(e.Results) != 3 {
+			t.Fatalf("skip %t: got %d results, want 3", skip, len(e.Results))
+		}
+		if r := e.Results[1]; r.Index != 1 || r.Rows != 0 || (r.File == "") != skip {
+			t.Errorf("skip %t: got result set %d with %d rows in %q", skip, r.Index, r.Rows, r.File)
+		}
+		if e.Output.FileNum != 3 {
+			t.Errorf("skip %t: the next file number is %d, want 3", skip, e.Output.FileNum)
+		}
+	}
+}
+
+func TestHeaderOnlySQL(t *testing.T) {
+	tests := []struct {
+		script, want string
+	}{
+		{"SELECT * FROM orders", "SELECT * FROM orders\nLIMIT 0"},
+		// A join keeps its duplicate column names and the tables of its
+		// columns
+		{"SELECT a.id, b.id FROM a JOIN b ON b.a_id = a.id", "SELECT a.id, b.id FROM a JOIN b ON b.a_id = a.id\nLIMIT 0"},
+		{"SELECT * FROM orders LIMIT 10", "SELECT * FROM orders\nLIMIT 0"},
+		{"SELECT * FROM orders ORDER BY id LIMIT 10, 20", "SELECT * FROM orders ORDER BY id\nLIMIT 0"},
+		{"SELECT * FROM orders LIMIT 10 FOR UPDATE", "SELECT * FROM orders\nLIMIT 0 FOR UPDATE"},
+		{"SELECT * FROM orders LOCK IN SHARE MODE", "SELECT * FROM orders\nLIMIT 0 LOCK IN SHARE MODE"},
+		{"SELECT * FROM orders -- all of them", "SELECT * FROM orders -- all of them\nLIMIT 0"},
+		// Only the clauses of the statement itself count
+		{"SELECT * FROM (SELECT * FROM orders LIMIT 5) o", "SELECT * FROM (SELECT * FROM orders LIMIT 5) o\nLIMIT 0"},
+		{"SELECT 'limit 5', `limit` FROM t /* LIMIT 1 */", "SELECT 'limit 5', `limit` FROM t /* LIMIT 1 */\nLIMIT 0"},
+		{"WITH recent AS (SELECT * FROM orders LIMIT 5) SELECT * FROM recent", "WITH recent AS (SELECT * FROM orders LIMIT 5) SELECT * FROM recent\nLIMIT 0"},
+		{"(SELECT id FROM a) UNION (SELECT id FROM b) LIMIT 3", "(SELECT id FROM a) UNION (SELECT id FROM b)\nLIMIT 0"},
+		{"TABLE orders", "TABLE orders\nLIMIT 0"},
+		{"SELECT COUNT(*) INTO @n FROM orders", "SELECT COUNT(*) INTO @n FROM orders"},
+		{"SET @n = 5; SELECT @n", "SET @n = 5;\nSELECT @n\nLIMIT 0"},
+		{"SHOW TABLES", "SHOW TABLES"},
+	}
+	for _, tt := range tests {
+		if got := HeaderOnlySQL(tt.script); got != tt.want {
+			t.Errorf("HeaderOnlySQL(%q) = %q, want %q", tt.script, got, tt.want)
+		}
+	}
+}
+
+func TestExporterValidate(t *testing.T) {
+	expect := -1
+	tests := []struct {
+		e    Exporter
+		want string
+	}{
+		{Exporter{}, ""},
+		{Exporter{Output: OutputData{OutputTemplate: "out-%d.csv"}, Jobs: 4, MaxRowsTotal: 10}, ""},
+		{Exporter{Output: OutputData{Compress: "lz4"}}, `Invalid compression "lz4"`},
+		{Exporter{Output: OutputData{Encoding: "klingon"}}, "klingon"},
+		{Exporter{Output: OutputData{EncodingErrors: "skip"}}, `Invalid --encoding-errors "skip"`},
+		{Exporter{Output: OutputData{OnExisting: "append"}}, `Invalid --on-duplicate-file "append"`},
+		{Exporter{Output: OutputData{Tee: true}}, "--tee needs an --output"},
+		{Exporter{Output: OutputData{OutputTemplate: "s3:///key.csv"}}, "s3://"},
+		{Exporter{WriteOptions: WriteOptions{Sample: -1}}, "--sample can't be negative"},
+		{Exporter{WriteOptions: WriteOptions{HeaderOnly: true}, PaginateColumn: "id"}, "--header-only can't be used with"},
+		{Exporter{WriteOptions: WriteOptions{Footer: "{rows}"}, FooterFile: true}, "--footer-file needs an --output"},
+		{Exporter{WriteOptions: WriteOptions{Format: FormatJSON, Footer: "{rows}"}}, "use --footer-file with json and ndjson"},
+		{Exporter{MaxRowsTotal: -1}, "--max-rows-total can't be negative"},
+		{Exporter{MaxRowsPerSecond: -1}, "--max-rows-per-second can't be negative"},
+		{Exporter{QueryRetries: -1}, "--query-retries can't be negative"},
+		{Exporter{QueryRetries: 2, SingleTransaction: true}, "--query-retries can't be used with"},
+		{Exporter{WriteOptions: WriteOptions{Sample: 5, KeyColumn: "id"}}, "--sample can't be used with"},
+		{Exporter{WriteOptions: WriteOptions{PartitionBy: "region"}, Output: OutputData{OutputTemplate: "out.csv"}}, "--partition-by needs an --output with %s"},
+		{Exporter{WriteOptions: WriteOptions{PartitionBy: "region"}, Output: OutputData{OutputTemplate: "%s.csv", Tee: true}}, "--partition-by can't be used with"},
+		{Exporter{Output: OutputData{OutputTemplate: "%s.csv"}}, "%s in the output template is the value of the --partition-by column"},
+		{Exporter{Metadata: true}, "--metadata needs an --output"},
+		{Exporter{ExpectRows: &expect}, "--expect-rows can't be negative"},
+		{Exporter{ExpectRowsQuery: "select 1", MaxRowsTotal: 5}, "--expect-rows can't be used with"},
+		{Exporter{WriteOptions: WriteOptions{WatermarkColumn: "updated_at"}, Jobs: 2}, "--watermark-file can't be used with"},
+		{Exporter{PaginateColumn: "id", Output: OutputData{OutputTemplate: "page-%d.csv"}}, "--page-size must be greater than 0"},
+		{Exporter{PaginateColumn: "id", PageSize: 10, Output: OutputData{OutputTemplate: "page.csv"}}, "--paginate-column requires an output template"},
+		{Exporter{MergeHosts: true, Output: OutputData{OutputTemplate: "{host}.csv"}}, "--merge-hosts writes every host to the same output"},
+		{Exporter{MergeHosts: true, Prepared: true}, "--merge-hosts can't be used with"},
+		{Exporter{Jobs: 2, StrictColumns: true}, "--strict-columns can't be used with --jobs"},
+		{Exporter{Jobs: 2, SingleTransaction: true}, "--single-transaction can't be used with --jobs"},
+		{Exporter{Jobs: 2, Output: OutputData{OutputTemplate: "out-%d.csv", Tee: true}}, "--tee can't be used with --jobs"},
+	}
+	for i, tt := range tests {
+		err := tt.e.Validate()
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%d: %v", i, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%d: got %v, want an error containing %q", i, err, tt.want)
+		}
+	}
+}
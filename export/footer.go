@@ -0,0 +1,139 @@
+package export
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// footerTokens are the placeholders of the footer template
+	footerTokens = regexp.MustCompile(`^\{(rows|sum:(.+))\}$`)
+	// footerPlaceholder matches anything that looks like a placeholder
+	footerPlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+)
+
+// ValidateFooter checks that the footer template only uses the {rows} and
+// {sum:column} placeholders
+func ValidateFooter(template string) error {
+	for _, token := range footerPlaceholder.FindAllString(template, -1) {
+		if !footerTokens.MatchString(token) {
+			return fmt.Errorf("Invalid footer %q: unknown placeholder %s, expected {rows} or {sum:column}", template, token)
+		}
+	}
+	return nil
+}
+
+// footer is the footer template of a result set with the totals of the rows
+// written so far
+type footer struct {
+	template string
+	rows     int
+	sums     map[string]*columnSum
+}
+
+// columnSum adds up the values of a column exactly. The total is written with
+// as many decimal places as the most precise value.
+type columnSum struct {
+	index int
+	total big.Rat
+	scale int
+}
+
+// newFooter returns the footer for a result set, or nil if there isn't a
+// template. Every summed column must be in the result set.
+func newFooter(template string, columns []string) (*footer, error) {
+	if template == "" {
+		return nil, nil
+	}
+	f := &footer{template: template, sums: map[string]*columnSum{}}
+	for _, token := range footerPlaceholder.FindAllString(template, -1) {
+		m := footerTokens.FindStringSubmatch(token)
+		if m == nil || m[2] == "" {
+			continue
+		}
+		i := indexOf(columns, m[2])
+		if i < 0 {
+			return nil, fmt.Errorf("footer column %s isn't in the result set", m[2])
+		}
+		f.sums[m[2]] = &columnSum{index: i}
+	}
+	return f, nil
+}
+
+func (f *footer) add(values []sql.NullString) error {
+	f.rows++
+	for column, sum := range f.sums {
+		v := values[sum.index]
+		if !v.Valid {
+			continue
+		}
+		var r big.Rat
+		if _, ok := r.SetString(v.String); !ok {
+			return fmt.Errorf("can't add %q in column %s to the footer sum", v.String, column)
+		}
+		sum.total.Add(&sum.total, &r)
+		if _, frac, ok := strings.Cut(v.String, "."); ok {
+			frac, _, _ = strings.Cut(strings.ToLower(frac), "e")
+			sum.scale = max(sum.scale, len(frac))
+		}
+	}
+	return nil
+}
+
+func (f *footer) String() string {
+	return footerPlaceholder.ReplaceAllStringFunc(f.template, func(token string) string {
+		m := footerTokens.FindStringSubmatch(token)
+		switch {
+		case m == nil:
+			return token
+		case m[1] == "rows":
+			return strconv.Itoa(f.rows)
+		}
+		sum := f.sums[m[2]]
+		return sum.total.FloatString(sum.scale)
+	})
+}
+
+// footerWriter totals the rows as they're written and writes the footer after
+// the last one, either to the end of the output or to the file returned by
+// open
+type footerWriter struct {
+	RowWriter
+	footer *footer
+	output io.Writer
+	open   func() (io.WriteCloser, error)
+}
+
+func (w *footerWriter) WriteRow(values []sql.NullString) error {
+	if err := w.footer.add(values); err != nil {
+		return err
+	}
+	return w.RowWriter.WriteRow(values)
+}
+
+func (w *footerWriter) Flush() (err error) {
+	if err = w.RowWriter.Flush(); err != nil {
+		return
+	}
+	if w.open == nil {
+		_, err = fmt.Fprintln(w.output, w.footer)
+		return
+	}
+	file, err := w.open()
+	if err != nil {
+		return fmt.Errorf("writing footer: %w", err)
+	}
+	_, err = fmt.Fprintln(file, w.footer)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("writing footer: %w", err)
+	}
+	return
+}
@@ -0,0 +1,282 @@
+package export
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	FormatCSV    = "csv"
+	FormatTSV    = "tsv"
+	FormatTable  = "table"
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+	// FormatMySQL is the tab separated format LOAD DATA INFILE reads by
+	// default
+	FormatMySQL = "mysql"
+	// FormatVertical writes each row as a block of lines like mysql's \G
+	FormatVertical = "vertical"
+	// FormatTemplate renders each row through WriteOptions.Template
+	FormatTemplate = "template"
+)
+
+// RowWriter writes the header and rows of a single result set in a particular
+// format. Flush must be called once all rows have been written. An error from
+// any of the methods fails the export.
+type RowWriter interface {
+	WriteHeader(columns []string) error
+	WriteRow(values []sql.NullString) error
+	Flush() error
+}
+
+// NewRowWriterFunc creates the RowWriter for a result set. types describes
+// each of its columns.
+type NewRowWriterFunc func(output io.Writer, types []*sql.ColumnType, opts WriteOptions) (RowWriter, error)
+
+var (
+	formatsMu sync.RWMutex
+	formats   = map[string]NewRowWriterFunc{
+		FormatCSV: func(output io.Writer, types []*sql.ColumnType, opts WriteOptions) (RowWriter, error) {
+			return newCSVWriter(output, types, opts), nil
+		},
+		FormatTSV: func(output io.Writer, _ []*sql.ColumnType, _ WriteOptions) (RowWriter, error) {
+			return newTSVWriter(output, "NULL"), nil
+		},
+		FormatMySQL: func(output io.Writer, _ []*sql.ColumnType, _ WriteOptions) (RowWriter, error) {
+			return newTSVWriter(output, `\N`), nil
+		},
+		FormatTable: func(output io.Writer, _ []*sql.ColumnType, _ WriteOptions) (RowWriter, error) {
+			return &tableWriter{output: output}, nil
+		},
+		FormatVertical: func(output io.Writer, types []*sql.ColumnType, _ WriteOptions) (RowWriter, error) {
+			return newVerticalWriter(output, types), nil
+		},
+		FormatTemplate: func(output io.Writer, types []*sql.ColumnType, opts WriteOptions) (RowWriter, error) {
+			return newTemplateWriter(output, types, opts.Template)
+		},
+		FormatJSON: func(output io.Writer, types []*sql.ColumnType, opts WriteOptions) (RowWriter, error) {
+			return newJSONWriter(output, types, opts, false), nil
+		},
+		FormatNDJSON: func(output io.Writer, types []*sql.ColumnType, opts WriteOptions) (RowWriter, error) {
+			return newJSONWriter(output, types, opts, true), nil
+		},
+	}
+)
+
+// RegisterFormat makes a format available to WriteOptions.Format. Registering
+// a name that already exists replaces it.
+func RegisterFormat(name string, newRowWriter NewRowWriterFunc) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	formats[name] = newRowWriter
+}
+
+// Formats returns the names of every registered format
+func Formats() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupFormat(format string) (NewRowWriterFunc, bool) {
+	if format == "" {
+		format = FormatCSV
+	}
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	f, ok := formats[format]
+	return f, ok
+}
+
+func ValidateFormat(format string) error {
+	if _, ok := lookupFormat(format); !ok {
+		return fmt.Errorf("Invalid format %q, expected one of %s", format, strings.Join(Formats(), ", "))
+	}
+	return nil
+}
+
+// formatExtensions maps output file extensions to the format FormatFor picks
+var formatExtensions = map[string]string{
+	".csv":    FormatCSV,
+	".tsv":    FormatTSV,
+	".tab":    FormatTSV,
+	".txt":    FormatTable,
+	".json":   FormatJSON,
+	".ndjson": FormatNDJSON,
+	".jsonl":  FormatNDJSON,
+}
+
+// FormatFor returns the format matching the extension of filename, ignoring a
+// trailing compression extension, or "" when the extension isn't recognised.
+// It works on output templates too since the placeholders come before the
+// extension.
+func FormatFor(filename string) string {
+	for _, ext := range []string{".gz", ".zst", ".zstd"} {
+		if strings.HasSuffix(filename, ext) {
+			filename = strings.TrimSuffix(filename, ext)
+			break
+		}
+	}
+	return formatExtensions[strings.ToLower(path.Ext(filename))]
+}
+
+// contentTypes are the media types S3 and HTTP outputs of each format are
+// sent with. Formats that aren't listed, like the ones added with
+// RegisterFormat, are sent as application/octet-stream.
+var contentTypes = map[string]string{
+	FormatCSV:      "text/csv",
+	FormatTSV:      "text/tab-separated-values",
+	FormatMySQL:    "text/tab-separated-values",
+	FormatJSON:     "application/json",
+	FormatNDJSON:   "application/x-ndjson",
+	FormatTable:    "text/plain",
+	FormatVertical: "text/plain",
+	FormatTemplate: "text/plain",
+}
+
+// contentType returns the Content-Type of the output and, when it's
+// compressed, its Content-Encoding
+func contentType(data OutputData, filename string) (mediaType, encoding string) {
+	format := data.Format
+	if format == "" {
+		format = FormatCSV
+	}
+	mediaType, ok := contentTypes[format]
+	if !ok {
+		mediaType = "application/octet-stream"
+	}
+	switch CompressionFor(data.Compress, filename) {
+	case CompressGzip:
+		encoding = "gzip"
+	case CompressZstd:
+		encoding = "zstd"
+	}
+	return
+}
+
+func newRowWriter(output io.Writer, types []*sql.ColumnType, opts WriteOptions) (RowWriter, error) {
+	newRowWriter, ok := lookupFormat(opts.Format)
+	if !ok {
+		return nil, ValidateFormat(opts.Format)
+	}
+	return newRowWriter(output, types, opts)
+}
+
+// maxTableRows is how many rows the table format buffers to measure the column
+// widths. Rows past this are written with the widths measured so far.
+const maxTableRows = 1000
+
+// tableWriter renders a result set as an aligned ASCII table like the mysql
+// client does
+type tableWriter struct {
+	output    io.Writer
+	header    []string
+	rows      [][]string
+	widths    []int
+	streaming bool
+}
+
+func (w *tableWriter) WriteHeader(columns []string) error {
+	w.header = columns
+	w.measure(columns)
+	return nil
+}
+
+func (w *tableWriter) WriteRow(values []sql.NullString) error {
+	row := make([]string, len(values))
+	for i, v := range values {
+		row[i] = v.String
+		if !v.Valid {
+			row[i] = "NULL"
+		}
+	}
+	if w.streaming {
+		return w.writeLine(row)
+	}
+	w.rows = append(w.rows, row)
+	w.measure(row)
+	if len(w.rows) < maxTableRows {
+		return nil
+	}
+	slog.Warn(fmt.Sprintf("result set has more than %d rows, columns are sized to fit the first %d and may not line up after that", maxTableRows, maxTableRows))
+	w.streaming = true
+	return w.writeBuffered()
+}
+
+func (w *tableWriter) measure(row []string) {
+	if w.widths == nil {
+		w.widths = make([]int, len(row))
+	}
+	for i, v := range row {
+		w.widths[i] = max(w.widths[i], utf8.RuneCountInString(v))
+	}
+}
+
+// writeBuffered writes the header and every row buffered so far
+func (w *tableWriter) writeBuffered() (err error) {
+	if err = w.writeBorder(); err != nil {
+		return
+	}
+	if w.header != nil {
+		if err = w.writeLine(w.header); err != nil {
+			return
+		}
+		if err = w.writeBorder(); err != nil {
+			return
+		}
+	}
+	for _, row := range w.rows {
+		if err = w.writeLine(row); err != nil {
+			return
+		}
+	}
+	w.rows = nil
+	return
+}
+
+func (w *tableWriter) writeBorder() error {
+	var b strings.Builder
+	b.WriteString("+")
+	for _, width := range w.widths {
+		b.WriteString(strings.Repeat("-", width+2))
+		b.WriteString("+")
+	}
+	b.WriteString("\n")
+	_, err := io.WriteString(w.output, b.String())
+	return err
+}
+
+func (w *tableWriter) writeLine(row []string) error {
+	var b strings.Builder
+	b.WriteString("|")
+	for i, v := range row {
+		b.WriteString(" ")
+		b.WriteString(v)
+		b.WriteString(strings.Repeat(" ", max(w.widths[i]-utf8.RuneCountInString(v), 0)+1))
+		b.WriteString("|")
+	}
+	b.WriteString("\n")
+	_, err := io.WriteString(w.output, b.String())
+	return err
+}
+
+func (w *tableWriter) Flush() (err error) {
+	if !w.streaming {
+		if err = w.writeBuffered(); err != nil {
+			return
+		}
+	}
+	return w.writeBorder()
+}
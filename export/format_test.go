@@ -0,0 +1,25 @@
+package export
+
+import "testing"
+
+func TestContentType(t *testing.T) {
+	tests := []struct {
+		format, compress, filename string
+		mediaType, encoding        string
+	}{
+		{"", "", "s3://bucket/export.csv", "text/csv", ""},
+		{FormatCSV, "", "s3://bucket/export.csv.gz", "text/csv", "gzip"},
+		{FormatTSV, "", "s3://bucket/export.tsv.zst", "text/tab-separated-values", "zstd"},
+		{FormatJSON, CompressGzip, "https://example.com/upload", "application/json", "gzip"},
+		{FormatNDJSON, "", "https://example.com/upload", "application/x-ndjson", ""},
+		{FormatNDJSON, CompressNone, "s3://bucket/export.ndjson.gz", "application/x-ndjson", ""},
+		{FormatTable, "", "s3://bucket/export.txt", "text/plain", ""},
+		{"parquet", "", "s3://bucket/export.parquet", "application/octet-stream", ""},
+	}
+	for _, tt := range tests {
+		mediaType, encoding := contentType(OutputData{Format: tt.format, Compress: tt.compress}, tt.filename)
+		if mediaType != tt.mediaType || encoding != tt.encoding {
+			t.Errorf("contentType(%q, %q, %q) = %q, %q, want %q, %q", tt.format, tt.compress, tt.filename, mediaType, encoding, tt.mediaType, tt.encoding)
+		}
+	}
+}
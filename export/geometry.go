@@ -0,0 +1,301 @@
+package export
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	GeometryWKT     = "wkt"
+	GeometryGeoJSON = "geojson"
+)
+
+func ValidateGeometryFormat(format string) error {
+	switch format {
+	case "", GeometryWKT, GeometryGeoJSON:
+		return nil
+	}
+	return fmt.Errorf("Invalid geometry format %q, expected wkt or geojson", format)
+}
+
+// WKB geometry types
+const (
+	wkbPoint = iota + 1
+	wkbLineString
+	wkbPolygon
+	wkbMultiPoint
+	wkbMultiLineString
+	wkbMultiPolygon
+	wkbGeometryCollection
+)
+
+var wkbNames = map[uint32]string{
+	wkbPoint:              "Point",
+	wkbLineString:         "LineString",
+	wkbPolygon:            "Polygon",
+	wkbMultiPoint:         "MultiPoint",
+	wkbMultiLineString:    "MultiLineString",
+	wkbMultiPolygon:       "MultiPolygon",
+	wkbGeometryCollection: "GeometryCollection",
+}
+
+var errShortGeometry = errors.New("invalid geometry: unexpected end of data")
+
+type point [2]float64
+
+// geometry is a decoded WKB geometry. Only the fields used by its type are
+// set: Points for points and line strings, Rings for polygons and Children
+// for the multi types and collections.
+type geometry struct {
+	Type     uint32
+	Points   []point
+	Rings    [][]point
+	Children []geometry
+}
+
+// convertGeometry converts a value in MySQL's internal geometry format, a
+// 4 byte SRID followed by WKB, to WKT or GeoJSON
+func convertGeometry(value []byte, format string) (string, error) {
+	if len(value) < 4 {
+		return "", errShortGeometry
+	}
+	r := &wkbReader{data: value[4:]}
+	g, err := r.geometry()
+	if err != nil {
+		return "", err
+	}
+	if len(r.data) > 0 {
+		return "", fmt.Errorf("invalid geometry: %d unexpected bytes after the geometry", len(r.data))
+	}
+	var b strings.Builder
+	if format == GeometryGeoJSON {
+		writeGeoJSON(&b, g)
+	} else {
+		writeWKT(&b, g, true)
+	}
+	return b.String(), nil
+}
+
+type wkbReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+func (r *wkbReader) uint32() (uint32, error) {
+	if len(r.data) < 4 {
+		return 0, errShortGeometry
+	}
+	v := r.order.Uint32(r.data)
+	r.data = r.data[4:]
+	return v, nil
+}
+
+// count reads the number of elements that follow, rejecting counts that
+// couldn't possibly fit in the remaining data
+func (r *wkbReader) count(minSize int) (int, error) {
+	n, err := r.uint32()
+	if err != nil {
+		return 0, err
+	}
+	if int64(n)*int64(minSize) > int64(len(r.data)) {
+		return 0, errShortGeometry
+	}
+	return int(n), nil
+}
+
+func (r *wkbReader) point() (p point, err error) {
+	if len(r.data) < 16 {
+		return p, errShortGeometry
+	}
+	p[0] = math.Float64frombits(r.order.Uint64(r.data))
+	p[1] = math.Float64frombits(r.order.Uint64(r.data[8:]))
+	r.data = r.data[16:]
+	// Neither WKT nor GeoJSON can represent NaN or infinity
+	for _, c := range p {
+		if math.IsNaN(c) || math.IsInf(c, 0) {
+			return p, fmt.Errorf("invalid geometry: coordinate %v isn't a finite number", c)
+		}
+	}
+	return
+}
+
+func (r *wkbReader) points() (points []point, err error) {
+	n, err := r.count(16)
+	if err != nil {
+		return
+	}
+	points = make([]point, n)
+	for i := range points {
+		if points[i], err = r.point(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (r *wkbReader) geometry() (g geometry, err error) {
+	if len(r.data) < 1 {
+		return g, errShortGeometry
+	}
+	switch r.data[0] {
+	case 0:
+		r.order = binary.BigEndian
+	case 1:
+		r.order = binary.LittleEndian
+	default:
+		return g, fmt.Errorf("invalid geometry: unknown byte order %d", r.data[0])
+	}
+	r.data = r.data[1:]
+	if g.Type, err = r.uint32(); err != nil {
+		return
+	}
+	switch g.Type {
+	case wkbPoint:
+		var p point
+		p, err = r.point()
+		g.Points = []point{p}
+	case wkbLineString:
+		g.Points, err = r.points()
+	case wkbPolygon:
+		var n int
+		if n, err = r.count(4); err != nil {
+			return
+		}
+		g.Rings = make([][]point, n)
+		for i := range g.Rings {
+			if g.Rings[i], err = r.points(); err != nil {
+				return
+			}
+		}
+	case wkbMultiPoint, wkbMultiLineString, wkbMultiPolygon, wkbGeometryCollection:
+		var n int
+		if n, err = r.count(5); err != nil {
+			return
+		}
+		g.Children = make([]geometry, n)
+		for i := range g.Children {
+			if g.Children[i], err = r.geometry(); err != nil {
+				return
+			}
+		}
+	default:
+		err = fmt.Errorf("invalid geometry: unknown type %d", g.Type)
+	}
+	return
+}
+
+func formatCoord(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func writeWKTPoints(b *strings.Builder, points []point) {
+	b.WriteString("(")
+	for i, p := range points {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(formatCoord(p[0]) + " " + formatCoord(p[1]))
+	}
+	b.WriteString(")")
+}
+
+// writeWKT writes the geometry as WKT. The type name is left off of the
+// children of multi geometries.
+func writeWKT(b *strings.Builder, g geometry, named bool) {
+	if named {
+		b.WriteString(strings.ToUpper(wkbNames[g.Type]))
+	}
+	switch g.Type {
+	case wkbPoint, wkbLineString:
+		writeWKTPoints(b, g.Points)
+		return
+	case wkbPolygon:
+		if len(g.Rings) == 0 {
+			b.WriteString(" EMPTY")
+			return
+		}
+		b.WriteString("(")
+		for i, ring := range g.Rings {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			writeWKTPoints(b, ring)
+		}
+		b.WriteString(")")
+		return
+	}
+	if len(g.Children) == 0 {
+		b.WriteString(" EMPTY")
+		return
+	}
+	b.WriteString("(")
+	for i, child := range g.Children {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		writeWKT(b, child, g.Type == wkbGeometryCollection)
+	}
+	b.WriteString(")")
+}
+
+func writeGeoJSONPoints(b *strings.Builder, points []point) {
+	b.WriteString("[")
+	for i, p := range points {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString("[" + formatCoord(p[0]) + "," + formatCoord(p[1]) + "]")
+	}
+	b.WriteString("]")
+}
+
+// writeGeoJSONCoordinates writes the coordinates member of a GeoJSON geometry
+func writeGeoJSONCoordinates(b *strings.Builder, g geometry) {
+	switch g.Type {
+	case wkbPoint:
+		p := g.Points[0]
+		b.WriteString("[" + formatCoord(p[0]) + "," + formatCoord(p[1]) + "]")
+	case wkbLineString:
+		writeGeoJSONPoints(b, g.Points)
+	case wkbPolygon:
+		b.WriteString("[")
+		for i, ring := range g.Rings {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			writeGeoJSONPoints(b, ring)
+		}
+		b.WriteString("]")
+	default:
+		b.WriteString("[")
+		for i, child := range g.Children {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			writeGeoJSONCoordinates(b, child)
+		}
+		b.WriteString("]")
+	}
+}
+
+func writeGeoJSON(b *strings.Builder, g geometry) {
+	b.WriteString(`{"type":"` + wkbNames[g.Type] + `",`)
+	if g.Type == wkbGeometryCollection {
+		b.WriteString(`"geometries":[`)
+		for i, child := range g.Children {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			writeGeoJSON(b, child)
+		}
+		b.WriteString("]}")
+		return
+	}
+	b.WriteString(`"coordinates":`)
+	writeGeoJSONCoordinates(b, g)
+	b.WriteString("}")
+}
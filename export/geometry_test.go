@@ -0,0 +1,157 @@
+package export
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+)
+
+// encodeWKB encodes the geometry in MySQL's internal format with an SRID of 0
+func encodeWKB(order binary.AppendByteOrder, g geometry) []byte {
+	return appendWKB([]byte{0, 0, 0, 0}, order, g)
+}
+
+func appendWKB(b []byte, order binary.AppendByteOrder, g geometry) []byte {
+	if order == binary.BigEndian {
+		b = append(b, 0)
+	} else {
+		b = append(b, 1)
+	}
+	b = order.AppendUint32(b, g.Type)
+	appendPoint := func(b []byte, p point) []byte {
+		b = order.AppendUint64(b, math.Float64bits(p[0]))
+		return order.AppendUint64(b, math.Float64bits(p[1]))
+	}
+	appendPoints := func(b []byte, points []point) []byte {
+		b = order.AppendUint32(b, uint32(len(points)))
+		for _, p := range points {
+			b = appendPoint(b, p)
+		}
+		return b
+	}
+	switch g.Type {
+	case wkbPoint:
+		b = appendPoint(b, g.Points[0])
+	case wkbLineString:
+		b = appendPoints(b, g.Points)
+	case wkbPolygon:
+		b = order.AppendUint32(b, uint32(len(g.Rings)))
+		for _, ring := range g.Rings {
+			b = appendPoints(b, ring)
+		}
+	default:
+		b = order.AppendUint32(b, uint32(len(g.Children)))
+		for _, child := range g.Children {
+			b = appendWKB(b, order, child)
+		}
+	}
+	return b
+}
+
+func TestConvertGeometry(t *testing.T) {
+	square := []point{{0, 0}, {1, 0}, {1, 1}, {0, 0}}
+	tests := []struct {
+		g            geometry
+		wkt, geoJSON string
+	}{
+		{
+			geometry{Type: wkbPoint, Points: []point{{1, -2.5}}},
+			"POINT(1 -2.5)",
+			`{"type":"Point","coordinates":[1,-2.5]}`,
+		},
+		{
+			geometry{Type: wkbLineString, Points: []point{{0, 0}, {1.5, 2}}},
+			"LINESTRING(0 0,1.5 2)",
+			`{"type":"LineString","coordinates":[[0,0],[1.5,2]]}`,
+		},
+		{
+			geometry{Type: wkbPolygon, Rings: [][]point{square}},
+			"POLYGON((0 0,1 0,1 1,0 0))",
+			`{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]}`,
+		},
+		{
+			geometry{Type: wkbMultiPoint, Children: []geometry{
+				{Type: wkbPoint, Points: []point{{1, 2}}},
+				{Type: wkbPoint, Points: []point{{3, 4}}},
+			}},
+			"MULTIPOINT((1 2),(3 4))",
+			`{"type":"MultiPoint","coordinates":[[1,2],[3,4]]}`,
+		},
+		{
+			geometry{Type: wkbMultiLineString, Children: []geometry{
+				{Type: wkbLineString, Points: []point{{0, 0}, {1, 1}}},
+				{Type: wkbLineString, Points: []point{{2, 2}, {3, 3}}},
+			}},
+			"MULTILINESTRING((0 0,1 1),(2 2,3 3))",
+			`{"type":"MultiLineString","coordinates":[[[0,0],[1,1]],[[2,2],[3,3]]]}`,
+		},
+		{
+			geometry{Type: wkbMultiPolygon, Children: []geometry{
+				{Type: wkbPolygon, Rings: [][]point{square}},
+			}},
+			"MULTIPOLYGON(((0 0,1 0,1 1,0 0)))",
+			`{"type":"MultiPolygon","coordinates":[[[[0,0],[1,0],[1,1],[0,0]]]]}`,
+		},
+		{
+			geometry{Type: wkbGeometryCollection, Children: []geometry{
+				{Type: wkbPoint, Points: []point{{1, 2}}},
+				{Type: wkbLineString, Points: []point{{0, 0}, {1, 1}}},
+			}},
+			"GEOMETRYCOLLECTION(POINT(1 2),LINESTRING(0 0,1 1))",
+			`{"type":"GeometryCollection","geometries":[{"type":"Point","coordinates":[1,2]},{"type":"LineString","coordinates":[[0,0],[1,1]]}]}`,
+		},
+		{
+			geometry{Type: wkbGeometryCollection},
+			"GEOMETRYCOLLECTION EMPTY",
+			`{"type":"GeometryCollection","geometries":[]}`,
+		},
+	}
+	for _, order := range []binary.AppendByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for _, tt := range tests {
+			value := encodeWKB(order, tt.g)
+			for format, want := range map[string]string{GeometryWKT: tt.wkt, GeometryGeoJSON: tt.geoJSON} {
+				got, err := convertGeometry(value, format)
+				if err != nil {
+					t.Errorf("%s %s %s: %v", order, wkbNames[tt.g.Type], format, err)
+					continue
+				}
+				if got != want {
+					t.Errorf("%s %s %s: got %s, want %s", order, wkbNames[tt.g.Type], format, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestConvertGeometryErrors(t *testing.T) {
+	valid := encodeWKB(binary.LittleEndian, geometry{Type: wkbPoint, Points: []point{{1, 2}}})
+	unknown := append([]byte(nil), valid...)
+	binary.LittleEndian.PutUint32(unknown[5:], 8)
+	order := append([]byte(nil), valid...)
+	order[4] = 2
+	tests := []struct {
+		name  string
+		value []byte
+		want  string
+	}{
+		{"no srid", []byte{0, 0}, "unexpected end of data"},
+		{"no wkb", valid[:4], "unexpected end of data"},
+		{"truncated point", valid[:len(valid)-1], "unexpected end of data"},
+		{"truncated line string", encodeWKB(binary.BigEndian, geometry{Type: wkbLineString, Points: []point{{0, 0}, {1, 1}}})[:30], "unexpected end of data"},
+		// A count far larger than the data is rejected before anything
+		// is allocated for it
+		{"huge count", append(valid[:5:5], 2, 0, 0, 0, 0xff, 0xff, 0xff, 0xff), "unexpected end of data"},
+		{"unknown type", unknown, "unknown type 8"},
+		{"unknown byte order", order, "unknown byte order 2"},
+		{"trailing bytes", append(valid[:len(valid):len(valid)], 0, 0), "2 unexpected bytes after the geometry"},
+		{"nan", encodeWKB(binary.LittleEndian, geometry{Type: wkbPoint, Points: []point{{math.NaN(), 0}}}), "NaN isn't a finite number"},
+		{"infinity", encodeWKB(binary.BigEndian, geometry{Type: wkbLineString, Points: []point{{0, 0}, {0, math.Inf(-1)}}}), "-Inf isn't a finite number"},
+	}
+	for _, tt := range tests {
+		_, err := convertGeometry(tt.value, GeometryGeoJSON)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.want)
+		}
+	}
+}
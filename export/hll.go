@@ -0,0 +1,59 @@
+package export
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// hllPrecision is the number of bits of the hash that pick a register. 2^14
+// registers take 16 KiB per column and estimate within about 1%.
+const hllPrecision = 14
+
+// hyperLogLog estimates the number of distinct values it has seen without
+// keeping the values
+type hyperLogLog struct {
+	registers [1 << hllPrecision]uint8
+}
+
+func (h *hyperLogLog) add(value string) {
+	x := hash64(value)
+	i := x >> (64 - hllPrecision)
+	// The bit after the index bits bounds the count of leading zeros
+	rest := x<<hllPrecision | 1<<(hllPrecision-1)
+	if rank := uint8(bits.LeadingZeros64(rest)) + 1; rank > h.registers[i] {
+		h.registers[i] = rank
+	}
+}
+
+// count returns the estimate. Linear counting is used while many registers
+// are still empty since it's much more accurate for small sets.
+func (h *hyperLogLog) count() uint64 {
+	m := float64(len(h.registers))
+	sum, zeros := 0.0, 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(math.Round(estimate))
+}
+
+// hash64 is FNV-1a followed by the SplitMix64 finalizer, which spreads the
+// bits of similar values such as consecutive ids across the whole hash
+func hash64(value string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
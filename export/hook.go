@@ -0,0 +1,53 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// runHook runs the OnComplete command once the output file of the result set
+// has been closed. Result sets written to stdout or skipped for being empty
+// don't have a file so they don't run it.
+func (e *Exporter) runHook(ctx context.Context, r Result) error {
+	if e.OnComplete == "" || r.File == "" {
+		return nil
+	}
+	command := strings.NewReplacer(
+		"{file}", shellQuote(r.File),
+		"{rows}", strconv.Itoa(r.Rows),
+		"{index}", strconv.Itoa(r.Index),
+	).Replace(e.OnComplete)
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	// stdout may be the output itself with --tee
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "MYSQL2CSV_FILE="+r.File, "MYSQL2CSV_ROWS="+strconv.Itoa(r.Rows), "MYSQL2CSV_INDEX="+strconv.Itoa(r.Index))
+	slog.Debug("running completion hook", "file", r.File, "command", command)
+	if err := cmd.Run(); err != nil {
+		if e.IgnoreHookErrors {
+			slog.Warn(fmt.Sprintf("the completion hook for %s failed: %s", r.File, err), "file", r.File, "error", err)
+			return nil
+		}
+		return fmt.Errorf("Error running the completion hook for %s: %w", r.File, err)
+	}
+	return nil
+}
+
+// shellQuote quotes s so the shell passes it to the hook as a single argument
+func shellQuote(s string) string {
+	if runtime.GOOS == "windows" {
+		return `"` + s + `"`
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,263 @@
+package export
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// SourceHostColumn is the column MergeHosts adds in front of the others with
+// the Name of the host each row came from
+const SourceHostColumn = "source_host"
+
+// Host is one of the servers the queries run on with Exporter.Hosts
+type Host struct {
+	// Name fills in the {host} of the output template and the
+	// SourceHostColumn, and identifies the server in errors
+	Name      string
+	DB        *sql.DB
+	MaskedDSN string
+}
+
+// HostFailure is a host that failed when FailFast wasn't set
+type HostFailure struct {
+	Host string
+	Err  error
+}
+
+// HostsError is returned when the export failed on some of the Hosts. The
+// others were exported.
+type HostsError struct {
+	Failures []HostFailure
+	Total    int
+}
+
+func (e *HostsError) Error() string {
+	// MergeHosts can skip a host for more than one query
+	hosts := map[string]bool{}
+	for _, f := range e.Failures {
+		hosts[f.Host] = true
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d of %d hosts failed", len(hosts), e.Total)
+	for _, f := range e.Failures {
+		b.WriteString("\n  " + f.Host + ": " + f.Err.Error())
+	}
+	return b.String()
+}
+
+func (e *HostsError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = f.Err
+	}
+	return errs
+}
+
+// runHosts exports the queries on each of the Hosts in turn, each like a Run
+// of its own that writes the {host} files of the host
+func (e *Exporter) runHosts(ctx context.Context) error {
+	// MaxRowsTotal caps the rows of every host together
+	e.budget = newRowBudget(e.MaxRowsTotal)
+	defer func() { e.budget = nil }()
+	var failures []HostFailure
+	for _, host := range e.Hosts {
+		if e.budget.spent() {
+			break
+		}
+		sub := *e
+		sub.Hosts = nil
+		sub.DB, sub.MaskedDSN = host.DB, host.MaskedDSN
+		sub.Output.Host = host.Name
+		sub.Results, sub.Failures, sub.prevCols = nil, nil, nil
+		// The rows are counted once every host has been exported
+		sub.ExpectRows, sub.ExpectRowsQuery = nil, ""
+		err := sub.Run(ctx)
+		e.Results = append(e.Results, sub.Results...)
+		e.Failures = append(e.Failures, sub.Failures...)
+		e.Retries += sub.Retries
+		e.Throttled += sub.Throttled
+		if err == nil {
+			continue
+		}
+		if e.FailFast || ctx.Err() != nil {
+			return err
+		}
+		slog.Warn(fmt.Sprintf("the export failed on %s, carrying on with the other hosts: %s", host.Name, err), "host", host.Name, "error", err)
+		failures = append(failures, HostFailure{Host: host.Name, Err: err})
+	}
+	if len(failures) > 0 {
+		return &HostsError{Failures: failures, Total: len(e.Hosts)}
+	}
+	e.logBudgetReached()
+	return e.verifyRows(ctx)
+}
+
+// hostsError returns a HostsError for the hosts MergeHosts skipped
+func (e *Exporter) hostsError() error {
+	if len(e.hostFailures) == 0 {
+		return nil
+	}
+	return &HostsError{Failures: e.hostFailures, Total: len(e.Hosts)}
+}
+
+// exportMerged writes the rows the query returns on every host as a single
+// result set. A host that can't be connected to or that rejects the query is
+// skipped unless FailFast is set.
+func (e *Exporter) exportMerged(ctx context.Context, query Query) (err error) {
+	rows := &mergedRows{ctx: ctx, e: e, query: query}
+	defer func() {
+		if cerr := rows.Close(); err == nil && cerr != nil {
+			err = &QueryError{Query: query.SQL, DSN: rows.host.MaskedDSN, Err: cerr}
+		}
+	}()
+	if !rows.openNext() {
+		if rows.err == nil {
+			// Every host was skipped
+			return e.hostsError()
+		}
+		return rows.err
+	}
+	return e.writeResultSets(ctx, query, e.withAddedColumns(rows, rows.addedTypes), "")
+}
+
+// mergedSQL prepends the SourceHostColumn with the name of the host to the
+// columns of the query. The name is given as hex so it needs no escaping.
+func mergedSQL(query, host string) string {
+	inner := strings.TrimRight(strings.TrimSpace(query), ";")
+	return fmt.Sprintf("SELECT CONVERT(X'%x' USING utf8mb4) AS %s, mysql2csv_merged.* FROM (%s) AS mysql2csv_merged", host, quoteIdentifier(SourceHostColumn), inner)
+}
+
+// mergedRows reads the rows of a query from each of the Hosts in turn. The
+// connection to the next host is only opened once the rows of the previous
+// one have been read. The columns must be the same on every host.
+type mergedRows struct {
+	ctx   context.Context
+	e     *Exporter
+	query Query
+	// next is the index of the next host to open
+	next    int
+	host    Host
+	conn    *sql.Conn
+	rows    *sql.Rows
+	columns []string
+	types   []*sql.ColumnType
+	// addedTypes are the types of the AddColumns, asked of the first host
+	addedTypes []*sql.ColumnType
+	err        error
+}
+
+// openNext runs the query on the next host that accepts it. It returns false
+// once there are no hosts left or when a host fails with FailFast.
+func (m *mergedRows) openNext() bool {
+	for m.next < len(m.e.Hosts) {
+		host := m.e.Hosts[m.next]
+		m.next++
+		err := m.open(host)
+		if err == nil {
+			return true
+		}
+		if m.e.FailFast || m.ctx.Err() != nil {
+			m.err = err
+			return false
+		}
+		slog.Warn(fmt.Sprintf("skipping %s: %s", host.Name, err), "host", host.Name, "error", err)
+		m.e.hostFailures = append(m.e.hostFailures, HostFailure{Host: host.Name, Err: err})
+	}
+	return false
+}
+
+func (m *mergedRows) open(host Host) (err error) {
+	conn, err := m.e.openConn(m.ctx, host.DB, host.MaskedDSN)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			conn.Close()
+		}
+	}()
+	if err = useDatabase(m.ctx, conn, m.query, host.MaskedDSN); err != nil {
+		return
+	}
+	if m.addedTypes == nil {
+		if m.addedTypes, err = addedColumnTypes(m.ctx, conn, m.e.AddColumns); err != nil {
+			return &QueryError{Query: m.query.SQL, DSN: host.MaskedDSN, Err: err}
+		}
+	}
+	rows, err := conn.QueryContext(m.ctx, mergedSQL(m.query.SQL, host.Name), m.query.Args...)
+	if err != nil {
+		return &QueryError{Query: m.query.SQL, DSN: host.MaskedDSN, Err: err}
+	}
+	columns, err := rows.Columns()
+	if err == nil && m.columns != nil && !slices.Equal(columns, m.columns) {
+		err = fmt.Errorf("The columns on %s (%s) are different from the columns on %s (%s)", host.Name, strings.Join(columns[1:], ", "), m.host.Name, strings.Join(m.columns[1:], ", "))
+	}
+	if err == nil && m.types == nil {
+		m.types, err = rows.ColumnTypes()
+	}
+	if err != nil {
+		rows.Close()
+		return
+	}
+	m.columns, m.host, m.conn, m.rows = columns, host, conn, rows
+	return nil
+}
+
+// closeCurrent closes the rows and connection of the current host
+func (m *mergedRows) closeCurrent() (err error) {
+	if m.rows != nil {
+		err = m.rows.Close()
+		m.conn.Close()
+		m.rows, m.conn = nil, nil
+	}
+	return
+}
+
+func (m *mergedRows) Columns() ([]string, error) {
+	return m.columns, nil
+}
+
+func (m *mergedRows) ColumnTypes() ([]*sql.ColumnType, error) {
+	return m.types, nil
+}
+
+// Next moves on to the next host once the rows of the current one run out.
+// A host that fails after some of its rows were written fails the query
+// since the rows can't be taken back.
+func (m *mergedRows) Next() bool {
+	for m.rows != nil && m.err == nil {
+		if m.rows.Next() {
+			return true
+		}
+		if err := m.rows.Err(); err != nil {
+			m.err = &QueryError{Query: m.query.SQL, DSN: m.host.MaskedDSN, Err: err}
+			return false
+		}
+		if err := m.closeCurrent(); err != nil {
+			m.err = &QueryError{Query: m.query.SQL, DSN: m.host.MaskedDSN, Err: err}
+			return false
+		}
+		m.openNext()
+	}
+	return false
+}
+
+func (m *mergedRows) Scan(dest ...any) error {
+	return m.rows.Scan(dest...)
+}
+
+func (m *mergedRows) NextResultSet() bool {
+	return false
+}
+
+func (m *mergedRows) Err() error {
+	return m.err
+}
+
+func (m *mergedRows) Close() error {
+	return m.closeCurrent()
+}
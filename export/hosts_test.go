@@ -0,0 +1,29 @@
+package export
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunHostsMaxRowsTotal(t *testing.T) {
+	queries := map[string][]testResultSet{"SELECT * FROM orders": {numberedRows(5)}}
+	e := &Exporter{
+		Queries:      []Query{{SQL: "SELECT * FROM orders"}},
+		Hosts:        []Host{{Name: "shard-1", DB: testDB(t, queries)}, {Name: "shard-2", DB: testDB(t, queries)}, {Name: "shard-3", DB: testDB(t, queries)}},
+		Output:       OutputData{OutputTemplate: filepath.Join(t.TempDir(), "{host}.csv")},
+		MaxRowsTotal: 7,
+	}
+	if err := e.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// The first host is exported in full, the second up to the limit and
+	// the third not at all
+	var rows []int
+	for _, r := range e.Results {
+		rows = append(rows, r.Rows)
+	}
+	if len(rows) != 2 || rows[0] != 5 || rows[1] != 2 {
+		t.Errorf("got %v rows from each host, want [5 2]", rows)
+	}
+}
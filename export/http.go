@@ -0,0 +1,105 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func IsHTTPPath(filename string) bool {
+	return strings.HasPrefix(filename, "http://") || strings.HasPrefix(filename, "https://")
+}
+
+// HTTPDestination sends each output as the body of a chunked POST request
+type HTTPDestination struct {
+	Context context.Context
+	Headers http.Header
+	Client  *http.Client
+}
+
+// ParseHTTPHeaders parses headers in the "Name: value" form used by curl
+func ParseHTTPHeaders(headers []string) (res http.Header, err error) {
+	res = make(http.Header)
+	for _, h := range headers {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("Invalid HTTP header %q, expected the form \"Name: value\"", h)
+		}
+		res.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return
+}
+
+// Create starts a POST request to the url. Everything written to the returned
+// writer is streamed as the request body and the request completes on Close.
+// The body is sent with the contentType, and the contentEncoding unless it's
+// empty.
+func (d *HTTPDestination) Create(url string, fileNum int, contentType, contentEncoding string) (output io.WriteCloser, err error) {
+	pr, pw := io.Pipe()
+	req, err := http.NewRequestWithContext(d.Context, http.MethodPost, url, pr)
+	if err != nil {
+		return
+	}
+	for name, values := range d.Headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", contentType)
+	if contentEncoding != "" {
+		req.Header.Set("Content-Encoding", contentEncoding)
+	}
+	req.Header.Set("X-Result-Set", strconv.Itoa(fileNum))
+
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	w := &httpWriter{url: url, pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := doRequest(client, req)
+		// Unblock any pending writes if the request failed
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w, nil
+}
+
+func doRequest(client *http.Client, req *http.Request) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+type httpWriter struct {
+	url  string
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (w *httpWriter) Write(p []byte) (n int, err error) {
+	return w.pw.Write(p)
+}
+
+// Close ends the request body and waits for the server to respond
+func (w *httpWriter) Close() error {
+	w.pw.Close()
+	if err := <-w.done; err != nil {
+		return fmt.Errorf("Error posting to %s: %w", w.url, err)
+	}
+	return nil
+}
+
+// Abort cancels the request so the server never sees a complete body
+func (w *httpWriter) Abort(err error) {
+	w.pw.CloseWithError(err)
+	<-w.done
+}
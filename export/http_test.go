@@ -0,0 +1,52 @@
+package export
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPOutputHeaders(t *testing.T) {
+	var header http.Header
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("reading the gzipped body: %s", err)
+			return
+		}
+		b, _ := io.ReadAll(gz)
+		body = string(b)
+	}))
+	defer server.Close()
+
+	data := OutputData{
+		OutputTemplate: server.URL + "/upload.ndjson.gz",
+		Format:         FormatNDJSON,
+		HTTP:           &HTTPDestination{Context: context.Background()},
+	}
+	var written int64
+	w, _, err := getOutput(data, &written)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, "{\"id\":\"1\",\"name\":\"name 1\"}\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := header.Get("Content-Type"); got != "application/x-ndjson" {
+		t.Errorf("got Content-Type %q, want application/x-ndjson", got)
+	}
+	if got := header.Get("Content-Encoding"); got != "gzip" {
+		t.Errorf("got Content-Encoding %q, want gzip", got)
+	}
+	if want := "{\"id\":\"1\",\"name\":\"name 1\"}\n"; body != want {
+		t.Errorf("got body %q, want %q", body, want)
+	}
+}
@@ -0,0 +1,186 @@
+package export
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// jsonKind is how the values of a column are encoded in JSON
+type jsonKind int
+
+const (
+	jsonString jsonKind = iota
+	jsonNumber
+	jsonBool
+	jsonRaw
+)
+
+var (
+	nullIntType   = reflect.TypeOf(sql.NullInt64{})
+	nullFloatType = reflect.TypeOf(sql.NullFloat64{})
+)
+
+// jsonKinds decides how each column is encoded. Without opts.Typed every
+// value is a string. The driver doesn't report the display width of integer
+// columns so TINYINT(1) can't be told apart from other TINYINTs and boolean
+// columns have to be listed in opts.BoolColumns.
+func jsonKinds(types []*sql.ColumnType, opts WriteOptions) []jsonKind {
+	kinds := make([]jsonKind, len(types))
+	if !opts.Typed {
+		return kinds
+	}
+	for i, t := range types {
+		dbType := t.DatabaseTypeName()
+		switch {
+		case isMasked(opts.Masks, t.Name()):
+			// Masked values are always strings whatever the column type
+		case indexOf(opts.BoolColumns, t.Name()) >= 0:
+			kinds[i] = jsonBool
+		case dbType == "JSON", dbType == "GEOMETRY" && opts.GeometryFormat == GeometryGeoJSON:
+			kinds[i] = jsonRaw
+		case dbType == "DECIMAL":
+			// Decimals are kept as strings by default since most JSON
+			// parsers decode numbers as floats and lose precision
+			if opts.DecimalAsNumber {
+				kinds[i] = jsonNumber
+			}
+		case isNumericScanType(t.ScanType()):
+			kinds[i] = jsonNumber
+		}
+	}
+	return kinds
+}
+
+// isNumericColumn reports whether the values of the column compare as
+// numbers. DECIMAL is scanned as text but is still a number.
+func isNumericColumn(t *sql.ColumnType) bool {
+	return t.DatabaseTypeName() == "DECIMAL" || isNumericScanType(t.ScanType())
+}
+
+func isNumericScanType(t reflect.Type) bool {
+	if t == nil {
+		return false
+	}
+	if t == nullIntType || t == nullFloatType {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// jsonWriter writes each row as a JSON object with the keys in column order.
+// Numbers are copied from the text MySQL sent so large unsigned integers keep
+// every digit.
+type jsonWriter struct {
+	output io.Writer
+	kinds  []jsonKind
+	keys   [][]byte
+	// lines writes newline delimited JSON instead of an array
+	lines bool
+	// omitNull leaves the keys of NULL values out of the object
+	omitNull bool
+	rows     int
+	buf      []byte
+}
+
+func newJSONWriter(output io.Writer, types []*sql.ColumnType, opts WriteOptions, lines bool) *jsonWriter {
+	w := &jsonWriter{output: output, kinds: jsonKinds(types, opts), lines: lines, omitNull: opts.JSONOmitNull}
+	columns := make([]string, len(types))
+	for i, t := range types {
+		columns[i] = t.Name()
+	}
+	w.setKeys(columns)
+	return w
+}
+
+func (w *jsonWriter) setKeys(columns []string) {
+	w.keys = make([][]byte, len(columns))
+	for i, c := range columns {
+		w.keys[i], _ = json.Marshal(c)
+	}
+}
+
+// WriteHeader only replaces the keys, which may have been renamed, since the
+// column names are part of every row
+func (w *jsonWriter) WriteHeader(columns []string) error {
+	w.setKeys(columns)
+	return nil
+}
+
+func (w *jsonWriter) WriteRow(values []sql.NullString) (err error) {
+	b := w.buf[:0]
+	switch {
+	case w.lines:
+	case w.rows == 0:
+		b = append(b, "[\n"...)
+	default:
+		b = append(b, ",\n"...)
+	}
+	b = append(b, '{')
+	empty := true
+	for i, v := range values {
+		if !v.Valid && w.omitNull {
+			continue
+		}
+		if !empty {
+			b = append(b, ',')
+		}
+		empty = false
+		b = append(b, w.keys[i]...)
+		b = append(b, ':')
+		if b, err = appendJSONValue(b, v, w.kinds[i]); err != nil {
+			return
+		}
+	}
+	b = append(b, '}')
+	if w.lines {
+		b = append(b, '\n')
+	}
+	w.buf = b
+	w.rows++
+	_, err = w.output.Write(b)
+	return
+}
+
+func appendJSONValue(b []byte, v sql.NullString, kind jsonKind) ([]byte, error) {
+	if !v.Valid {
+		return append(b, "null"...), nil
+	}
+	switch kind {
+	case jsonNumber:
+		if v.String != "" && json.Valid([]byte(v.String)) {
+			return append(b, v.String...), nil
+		}
+	case jsonBool:
+		if v.String == "0" || strings.EqualFold(v.String, "false") || v.String == "" {
+			return append(b, "false"...), nil
+		}
+		return append(b, "true"...), nil
+	case jsonRaw:
+		if json.Valid([]byte(v.String)) {
+			return append(b, v.String...), nil
+		}
+	}
+	s, err := json.Marshal(v.String)
+	return append(b, s...), err
+}
+
+func (w *jsonWriter) Flush() (err error) {
+	if w.lines {
+		return
+	}
+	end := "\n]\n"
+	if w.rows == 0 {
+		end = "[]\n"
+	}
+	_, err = io.WriteString(w.output, end)
+	return
+}
@@ -0,0 +1,30 @@
+package export
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestJSONKeysDedupeHeaders(t *testing.T) {
+	set := testResultSet{columns: []string{"id", "name", "id"}, rows: [][]string{{"1", "ann", "7"}}}
+	tests := []struct {
+		format, want string
+	}{
+		{FormatJSON, "[\n{\"id\":\"1\",\"name\":\"ann\",\"id_2\":\"7\"}\n]\n"},
+		{FormatNDJSON, "{\"id\":\"1\",\"name\":\"ann\",\"id_2\":\"7\"}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var b bytes.Buffer
+			open := func() (io.WriteCloser, error) { return NopCloser{&b}, nil }
+			opts := WriteOptions{Format: tt.format, DedupeHeaders: true}
+			if _, err := writeResultSet(testRows(t, set), open, opts); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != tt.want {
+				t.Errorf("got %q, want %q", b.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package export
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+type manifestFile struct {
+	Path     string  `json:"path"`
+	Rows     int     `json:"rows"`
+	Bytes    int64   `json:"bytes"`
+	Query    string  `json:"query"`
+	FirstKey *string `json:"first_key,omitempty"`
+	LastKey  *string `json:"last_key,omitempty"`
+	// Partition is the value of the partition column of the rows in the file
+	Partition string `json:"partition,omitempty"`
+	// BlobFiles and BlobBytes are the files written to the blob directory
+	// for this file, which aren't part of Bytes
+	BlobFiles int   `json:"blob_files,omitempty"`
+	BlobBytes int64 `json:"blob_bytes,omitempty"`
+}
+
+type manifest struct {
+	Files []manifestFile `json:"files"`
+}
+
+// WriteManifest lists every file that was created in the results. The
+// manifest is written to a temporary file first and renamed into place so a
+// partially written manifest is never seen.
+func WriteManifest(filename string, results []Result) (err error) {
+	m := manifest{Files: []manifestFile{}}
+	for _, r := range results {
+		if r.File == "" {
+			continue
+		}
+		m.Files = append(m.Files, manifestFile{
+			Path:      r.File,
+			Rows:      r.Rows,
+			Bytes:     r.Bytes,
+			Query:     r.Query.SQL,
+			FirstKey:  r.FirstKey,
+			LastKey:   r.LastKey,
+			Partition: r.Partition,
+			BlobFiles: r.BlobFiles,
+			BlobBytes: r.BlobBytes,
+		})
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return
+	}
+	return writeFileAtomic(filename, append(data, '\n'))
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory and
+// renames it to filename
+func writeFileAtomic(filename string, data []byte) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp*")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return
+	}
+	return os.Rename(tmp.Name(), filename)
+}
@@ -0,0 +1,96 @@
+package export
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	MaskFull = "full"
+	MaskHash = "hash"
+	// MaskLast keeps the last N characters, e.g. last4
+	MaskLast = "last"
+)
+
+// ColumnMask redacts the values of a single column
+type ColumnMask struct {
+	Column string
+	Mode   string
+	// Keep is how many trailing characters MaskLast leaves visible
+	Keep int
+}
+
+// ParseMasks parses --mask values in the form column or column=mode where
+// mode is full, hash or lastN
+func ParseMasks(values []string) (masks []ColumnMask, err error) {
+	for _, v := range values {
+		column, mode, _ := strings.Cut(v, "=")
+		column = strings.TrimSpace(column)
+		mode = strings.TrimSpace(mode)
+		if column == "" {
+			return nil, fmt.Errorf("Invalid --mask %q, expected column or column=mode", v)
+		}
+		mask := ColumnMask{Column: column, Mode: mode}
+		switch {
+		case mode == "":
+			mask.Mode = MaskFull
+		case mode == MaskFull, mode == MaskHash:
+		case strings.HasPrefix(mode, MaskLast):
+			mask.Mode = MaskLast
+			if mask.Keep, err = strconv.Atoi(strings.TrimPrefix(mode, MaskLast)); err != nil || mask.Keep < 0 {
+				return nil, fmt.Errorf("Invalid --mask %q, expected a number of characters to keep such as last4", v)
+			}
+		default:
+			return nil, fmt.Errorf("Invalid --mask %q, expected a mode of full, hash or lastN", v)
+		}
+		masks = append(masks, mask)
+	}
+	return masks, nil
+}
+
+// maskValue redacts a single value. Hashes are salted so the same value maps to
+// the same hash across exports that use the same salt.
+func maskValue(mask ColumnMask, salt, value string) string {
+	switch mask.Mode {
+	case MaskHash:
+		sum := sha256.Sum256([]byte(salt + value))
+		return hex.EncodeToString(sum[:])
+	case MaskLast:
+		runes := []rune(value)
+		hidden := len(runes) - mask.Keep
+		if hidden <= 0 {
+			return value
+		}
+		return strings.Repeat("*", hidden) + string(runes[hidden:])
+	}
+	return "***"
+}
+
+// masksFor returns the mask for each column of a result set, erroring if a
+// masked column isn't in it
+func masksFor(columns []string, masks []ColumnMask) ([]*ColumnMask, error) {
+	if len(masks) == 0 {
+		return nil, nil
+	}
+	byColumn := make([]*ColumnMask, len(columns))
+	for i := range masks {
+		index := indexOf(columns, masks[i].Column)
+		if index < 0 {
+			return nil, fmt.Errorf("masked column %s isn't in the result set", masks[i].Column)
+		}
+		byColumn[index] = &masks[i]
+	}
+	return byColumn, nil
+}
+
+func isMasked(masks []ColumnMask, column string) bool {
+	for _, m := range masks {
+		if m.Column == column {
+			return true
+		}
+	}
+	return false
+}
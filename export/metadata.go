@@ -0,0 +1,58 @@
+package export
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// fileMetadata is the content of the .meta.json file written next to each
+// output with Metadata
+type fileMetadata struct {
+	File       string         `json:"file"`
+	Query      string         `json:"query"`
+	Parameters []any          `json:"parameters,omitempty"`
+	Database   string         `json:"database,omitempty"`
+	Table      string         `json:"table,omitempty"`
+	Partition  string         `json:"partition,omitempty"`
+	Started    time.Time      `json:"started"`
+	Finished   time.Time      `json:"finished"`
+	Rows       int            `json:"rows"`
+	Bytes      int64          `json:"bytes"`
+	Columns    []ColumnSchema `json:"columns"`
+	Version    string         `json:"mysql2csv_version,omitempty"`
+}
+
+// writeMetadata writes a .meta.json file describing the output of a result
+// set next to it, once the output has been closed so the counts are final.
+// It goes to the same destination as the output, which may be S3 or HTTP.
+func writeMetadata(data OutputData, res Result, version string) (err error) {
+	if res.File == "" {
+		return
+	}
+	contents, err := json.MarshalIndent(fileMetadata{
+		File:       res.File,
+		Query:      res.Query.SQL,
+		Parameters: res.Query.Args,
+		Database:   res.Query.Database,
+		Table:      res.Query.Table,
+		Partition:  res.Partition,
+		Started:    res.Started.UTC(),
+		Finished:   res.Finished.UTC(),
+		Rows:       res.Rows,
+		Bytes:      res.Bytes,
+		Columns:    res.Columns,
+		Version:    version,
+	}, "", "  ")
+	if err != nil {
+		return
+	}
+	sidecar, err := openDestination(data, res.File+".meta.json")
+	if err != nil {
+		return
+	}
+	_, err = sidecar.Write(append(contents, '\n'))
+	if cerr := sidecar.Close(); err == nil {
+		err = cerr
+	}
+	return
+}
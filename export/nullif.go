@@ -0,0 +1,57 @@
+package export
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// NullIf writes the values of Column that are exactly Value as NULL. An empty
+// Column matches every column.
+type NullIf struct {
+	Column string
+	Value  string
+}
+
+// ParseNullIfs parses --null-if values in the form value or column=value. A
+// value containing = that should apply to every column can be written as
+// =value.
+func ParseNullIfs(values []string) (nullIfs []NullIf) {
+	for _, v := range values {
+		column, value, ok := strings.Cut(v, "=")
+		if !ok {
+			column, value = "", v
+		}
+		nullIfs = append(nullIfs, NullIf{Column: strings.TrimSpace(column), Value: value})
+	}
+	return
+}
+
+// nullIfTransform returns the transform that turns the matching values of each
+// column into NULL, or nil if nothing can match. Columns that aren't in the
+// result set are ignored since a multi-table export rarely has the same
+// columns in every table.
+func nullIfTransform(columns []string, nullIfs []NullIf) valueTransform {
+	matches := make([]map[string]bool, len(columns))
+	matchAny := false
+	for i, c := range columns {
+		for _, n := range nullIfs {
+			if n.Column != "" && n.Column != c {
+				continue
+			}
+			if matches[i] == nil {
+				matches[i] = map[string]bool{}
+			}
+			matches[i][n.Value] = true
+			matchAny = true
+		}
+	}
+	if !matchAny {
+		return nil
+	}
+	return func(column int, v sql.NullString) (sql.NullString, error) {
+		if matches[column][v.String] {
+			return sql.NullString{}, nil
+		}
+		return v, nil
+	}
+}
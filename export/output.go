@@ -0,0 +1,305 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// What to do when a local output file already exists
+const (
+	FileOverwrite = "overwrite"
+	FileError     = "error"
+	// FileRename writes to the next free name, export(1).csv, export(2).csv...
+	FileRename = "rename"
+)
+
+func ValidateOnExisting(mode string) error {
+	switch mode {
+	case "", FileOverwrite, FileError, FileRename:
+		return nil
+	}
+	return fmt.Errorf("Invalid --on-duplicate-file %q, expected one of %s, %s or %s", mode, FileOverwrite, FileError, FileRename)
+}
+
+// OutputData describes where the result sets of an export are written
+type OutputData struct {
+	OutputTemplate string
+	FileNum        int
+	// Table, Database, Host, Query and Started fill in the named
+	// placeholders of the output template
+	Table    string
+	Database string
+	Host     string
+	Query    string
+	Started  time.Time
+	// Partition replaces %s with the value of the PartitionBy column
+	Partition string
+
+	// Format is the WriteOptions.Format of the rows, which sets the
+	// Content-Type of S3 and HTTP outputs
+	Format   string
+	Compress string
+	// Encoding is the character set the output is converted to from UTF-8
+	Encoding       string
+	EncodingErrors string
+	// Checksum writes a .sha256 file next to each output
+	Checksum bool
+	S3       *S3Destination
+	HTTP     *HTTPDestination
+	// OnExisting is what happens when a local output file already exists, one
+	// of FileOverwrite, the default, FileError or FileRename
+	OnExisting string
+	// WriteBuffer is the size of the buffer for writes to local files. Writes
+	// aren't buffered if it's 0.
+	WriteBuffer int
+	// Stdout is written to when there isn't an output template. It defaults
+	// to os.Stdout.
+	Stdout io.Writer
+	// Tee also writes every output file to Stdout as it's written, before
+	// it's compressed or encoded
+	Tee bool
+}
+
+// Validate checks the output options. The errors name the command line flags
+// of the options.
+func (data OutputData) Validate() (err error) {
+	if _, err = LookupEncoding(data.Encoding); err != nil {
+		return
+	}
+	if data.EncodingErrors != "" {
+		if err = ValidateEncodingErrors(data.EncodingErrors); err != nil {
+			return
+		}
+	}
+	if err = ValidateCompression(data.Compress); err != nil {
+		return
+	}
+	if err = ValidateOutputTemplate(data.OutputTemplate); err != nil {
+		return
+	}
+	if err = ValidateOnExisting(data.OnExisting); err != nil {
+		return
+	}
+	if data.Tee && data.OutputTemplate == "" {
+		return fmt.Errorf("--tee needs an --output to write to as well as stdout")
+	}
+	if IsS3Path(data.OutputTemplate) {
+		if _, _, err = ParseS3Path(data.OutputTemplate); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (data OutputData) stdout() io.Writer {
+	if data.Stdout == nil {
+		return os.Stdout
+	}
+	return data.Stdout
+}
+
+// getOutput opens the output for the current result set and returns the file
+// it was written to, which differs from the output template with FileRename.
+// The number of bytes written to the destination, after compression, is added
+// to written.
+func getOutput(data OutputData, written *int64) (output io.WriteCloser, filename string, err error) {
+	if filename, err = resolveFilename(data, outputFilename(data)); err != nil {
+		return
+	}
+	if output, err = openDestination(data, filename); err != nil {
+		return nil, "", err
+	}
+	output = &countingWriter{WriteCloser: output, written: written}
+	if data.Checksum {
+		output = newChecksumWriter(output, filename, data)
+	}
+	compressed, err := compressOutput(CompressionFor(data.Compress, filename), output)
+	if err != nil {
+		output.Close()
+		return nil, "", err
+	}
+	encoded, err := encodeOutput(data.Encoding, data.EncodingErrors, compressed)
+	if err != nil {
+		compressed.Close()
+		return nil, "", err
+	}
+	if data.Tee && filename != "" {
+		return &teeWriter{WriteCloser: encoded, tee: data.stdout()}, filename, nil
+	}
+	return encoded, filename, nil
+}
+
+// isLocalFile reports whether filename is a file on disk rather than stdout,
+// an S3 object or a URL
+func isLocalFile(filename string) bool {
+	return filename != "" && !IsS3Path(filename) && !IsHTTPPath(filename)
+}
+
+// maxRenames is how many numbered names FileRename tries before giving up
+const maxRenames = 10000
+
+// resolveFilename applies OnExisting to a local file that already exists
+func resolveFilename(data OutputData, filename string) (string, error) {
+	if !isLocalFile(filename) || data.OnExisting == "" || data.OnExisting == FileOverwrite {
+		return filename, nil
+	}
+	exists, err := fileExists(filename)
+	if err != nil || !exists {
+		return filename, err
+	}
+	if data.OnExisting == FileError {
+		return "", fmt.Errorf("%s already exists", filename)
+	}
+	dir, base := filepath.Split(filename)
+	if base == "" {
+		return "", fmt.Errorf("%s is a directory, not a file that can be renamed", filename)
+	}
+	// The number goes before every extension so export.csv.gz becomes
+	// export(1).csv.gz
+	name, ext := splitExtensions(base)
+	for n := 1; n <= maxRenames; n++ {
+		renamed := filepath.Join(dir, fmt.Sprintf("%s(%d)%s", name, n, ext))
+		if exists, err := fileExists(renamed); err != nil || !exists {
+			return renamed, err
+		}
+	}
+	return "", fmt.Errorf("%s(1)%s to %s(%d)%s all exist", filepath.Join(dir, name), ext, filepath.Join(dir, name), maxRenames, ext)
+}
+
+// fileExists reports whether there's a file at filename. Errors other than
+// the file not existing, like a directory that can't be read, are returned.
+func fileExists(filename string) (bool, error) {
+	_, err := os.Stat(filename)
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// splitExtensions splits a file name before its first extension, so
+// export.csv.gz becomes export and .csv.gz. A leading dot, as in .env, is
+// part of the name.
+func splitExtensions(base string) (name, ext string) {
+	if base == "" {
+		return "", ""
+	}
+	if i := strings.Index(base[1:], "."); i >= 0 {
+		return base[:i+1], base[i+1:]
+	}
+	return base, ""
+}
+
+// teeWriter copies everything written to the output to another writer. A
+// failed write to either of them fails the export.
+type teeWriter struct {
+	io.WriteCloser
+	tee io.Writer
+}
+
+func (w *teeWriter) Write(p []byte) (int, error) {
+	if _, err := w.tee.Write(p); err != nil {
+		return 0, err
+	}
+	return w.WriteCloser.Write(p)
+}
+
+func (w *teeWriter) Abort(err error) {
+	abortOrClose(w.WriteCloser, err)
+}
+
+// openDestination opens the file, upload or request that filename refers to or
+// stdout if filename is empty
+func openDestination(data OutputData, filename string) (output io.WriteCloser, err error) {
+	switch {
+	case filename == "":
+		return NopCloser{data.stdout()}, nil
+	case IsS3Path(filename):
+		mediaType, encoding := contentType(data, filename)
+		return data.S3.Create(filename, mediaType, encoding)
+	case IsHTTPPath(filename):
+		mediaType, encoding := contentType(data, filename)
+		return data.HTTP.Create(filename, data.FileNum, mediaType, encoding)
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	file := &localFile{Writer: f, file: f}
+	if data.WriteBuffer > 0 {
+		file.buf = bufio.NewWriterSize(f, data.WriteBuffer)
+		file.Writer = file.buf
+	}
+	return file, nil
+}
+
+// localFile is an output file on disk. Writes are batched into fewer, larger
+// syscalls when it has a buffer, which matters a lot on network filesystems.
+type localFile struct {
+	io.Writer
+	file *os.File
+	buf  *bufio.Writer
+}
+
+// Close flushes what's left in the buffer before closing the file
+func (f *localFile) Close() (err error) {
+	if f.buf != nil {
+		err = f.buf.Flush()
+	}
+	if cerr := f.file.Close(); err == nil {
+		err = cerr
+	}
+	return
+}
+
+// Abort removes the file so a truncated export can't be mistaken for a
+// complete one
+func (f *localFile) Abort(error) {
+	f.file.Close()
+	os.Remove(f.file.Name())
+}
+
+// Aborter is implemented by outputs that need to discard what has been written
+// so far instead of committing it when writing fails
+type Aborter interface {
+	Abort(err error)
+}
+
+// abortOrClose aborts the output if it supports it and closes it otherwise
+func abortOrClose(output io.WriteCloser, err error) {
+	if a, ok := output.(Aborter); ok {
+		a.Abort(err)
+		return
+	}
+	output.Close()
+}
+
+type countingWriter struct {
+	io.WriteCloser
+	written *int64
+}
+
+func (w *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = w.WriteCloser.Write(p)
+	*w.written += int64(n)
+	return
+}
+
+func (w *countingWriter) Abort(err error) {
+	abortOrClose(w.WriteCloser, err)
+}
+
+type NopCloser struct {
+	io.Writer
+}
+
+func (NopCloser) Close() error {
+	return nil
+}
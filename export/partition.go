@@ -0,0 +1,98 @@
+package export
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+)
+
+// NullPartition is the name of the partition for rows where the PartitionBy
+// column is NULL
+const NullPartition = "NULL"
+
+// partition is the output of the rows with one value of the PartitionBy
+// column
+type partition struct {
+	output io.WriteCloser
+	writer RowWriter
+	res    Result
+}
+
+// partitions routes each row of a result set to the output for the value of
+// its PartitionBy column. Every output stays open until the result set has
+// been read since the rows aren't sorted by the column.
+type partitions struct {
+	column  int
+	columns []string
+	types   []*sql.ColumnType
+	opts    WriteOptions
+	byValue map[sql.NullString]*partition
+	// order is the order the partitions were first seen in, which is the
+	// order their results are reported in
+	order []*partition
+}
+
+func newPartitions(columns []string, types []*sql.ColumnType, opts WriteOptions) (*partitions, error) {
+	column := indexOf(columns, opts.PartitionBy)
+	if column < 0 {
+		return nil, fmt.Errorf("the partition column %s isn't in the result set", opts.PartitionBy)
+	}
+	return &partitions{column: column, columns: columns, types: types, opts: opts, byValue: map[sql.NullString]*partition{}}, nil
+}
+
+// get returns the partition of the row, opening its output and writing the
+// header when it's the first row with the value
+func (p *partitions) get(values []sql.NullString) (*partition, error) {
+	value := values[p.column]
+	if part, ok := p.byValue[value]; ok {
+		return part, nil
+	}
+	name := NullPartition
+	if value.Valid {
+		name = safeFileName(value.String)
+	}
+	output, err := p.opts.openPartition(name)
+	if err != nil {
+		return nil, err
+	}
+	part := &partition{output: output, res: Result{Partition: name}}
+	// Each file gets its own footer with the totals of its rows. The
+	// template was already checked for the result set.
+	footer, _ := newFooter(p.opts.Footer, p.columns)
+	if part.writer, err = newOutputWriter(output, p.columns, p.types, footer, p.opts); err != nil {
+		abortOrClose(output, err)
+		return nil, err
+	}
+	p.byValue[value] = part
+	p.order = append(p.order, part)
+	return part, nil
+}
+
+func (p *partitions) flush() error {
+	for _, part := range p.order {
+		if err := part.writer.Flush(); err != nil {
+			return fmt.Errorf("partition %s: %w", part.res.Partition, err)
+		}
+	}
+	return nil
+}
+
+// close closes every output, or discards them all when the export failed
+func (p *partitions) close(err error) (cerr error) {
+	for _, part := range p.order {
+		if err != nil {
+			abortOrClose(part.output, err)
+		} else if e := part.output.Close(); cerr == nil && e != nil {
+			cerr = fmt.Errorf("partition %s: %w", part.res.Partition, e)
+		}
+	}
+	return
+}
+
+func (p *partitions) results() []Result {
+	results := make([]Result, len(p.order))
+	for i, part := range p.order {
+		results[i] = part.res
+	}
+	return results
+}
@@ -0,0 +1,187 @@
+package export
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"unicode/utf8"
+)
+
+// profileHeader is the header of the column profile report
+var profileHeader = []string{"column", "type", "rows", "non_null", "null_percent", "distinct", "min", "max", "avg_length"}
+
+// columnProfile is the statistics of one column of a result set
+type columnProfile struct {
+	Column      string   `json:"column"`
+	Type        string   `json:"type"`
+	Rows        int      `json:"rows"`
+	NonNull     int      `json:"non_null"`
+	NullPercent *float64 `json:"null_percent"`
+	// Distinct is estimated with a HyperLogLog so it can be off by about 1%
+	// for columns with many values
+	Distinct  uint64   `json:"distinct"`
+	Min       *string  `json:"min"`
+	Max       *string  `json:"max"`
+	AvgLength *float64 `json:"avg_length"`
+
+	numeric        bool
+	minRat, maxRat big.Rat
+	length         int
+	distinct       hyperLogLog
+}
+
+func (p *columnProfile) add(v sql.NullString) {
+	p.Rows++
+	if !v.Valid {
+		return
+	}
+	p.NonNull++
+	p.length += utf8.RuneCountInString(v.String)
+	p.distinct.add(v.String)
+	if p.numeric {
+		var r big.Rat
+		if _, ok := r.SetString(v.String); ok {
+			if p.Min == nil || r.Cmp(&p.minRat) < 0 {
+				p.Min = &v.String
+				p.minRat.Set(&r)
+			}
+			if p.Max == nil || r.Cmp(&p.maxRat) > 0 {
+				p.Max = &v.String
+				p.maxRat.Set(&r)
+			}
+			return
+		}
+		// A value that isn't a number, e.g. after --replace, makes the
+		// column compare as text like every other column
+		p.numeric = false
+	}
+	if p.Min == nil || v.String < *p.Min {
+		p.Min = &v.String
+	}
+	if p.Max == nil || v.String > *p.Max {
+		p.Max = &v.String
+	}
+}
+
+// finish works out the totals once every row has been added
+func (p *columnProfile) finish() {
+	p.Distinct = min(p.distinct.count(), uint64(p.NonNull))
+	if p.Rows > 0 {
+		percent := math.Round(float64(p.Rows-p.NonNull)*1000/float64(p.Rows)) / 10
+		p.NullPercent = &percent
+	}
+	if p.NonNull > 0 {
+		avg := math.Round(float64(p.length)*100/float64(p.NonNull)) / 100
+		p.AvgLength = &avg
+	}
+}
+
+func (p *columnProfile) values() []sql.NullString {
+	values := []sql.NullString{
+		{String: p.Column, Valid: true},
+		{String: p.Type, Valid: true},
+		{String: strconv.Itoa(p.Rows), Valid: true},
+		{String: strconv.Itoa(p.NonNull), Valid: true},
+		{},
+		{String: strconv.FormatUint(p.Distinct, 10), Valid: true},
+		{},
+		{},
+		{},
+	}
+	if p.NullPercent != nil {
+		values[4] = sql.NullString{String: strconv.FormatFloat(*p.NullPercent, 'f', 1, 64), Valid: true}
+	}
+	if p.Min != nil {
+		values[6] = sql.NullString{String: *p.Min, Valid: true}
+		values[7] = sql.NullString{String: *p.Max, Valid: true}
+	}
+	if p.AvgLength != nil {
+		values[8] = sql.NullString{String: strconv.FormatFloat(*p.AvgLength, 'f', 2, 64), Valid: true}
+	}
+	return values
+}
+
+// profileWriter computes statistics of every column instead of writing the
+// rows and writes them as a report with a row per column when it's flushed.
+// Text formats write the report with their own writer, the JSON formats as
+// objects with numbers and nulls.
+type profileWriter struct {
+	// writer is nil for the JSON formats
+	writer   RowWriter
+	output   io.Writer
+	lines    bool
+	noHeader bool
+	columns  []*columnProfile
+}
+
+func newProfileWriter(output io.Writer, types []*sql.ColumnType, opts WriteOptions) (*profileWriter, error) {
+	w := &profileWriter{output: output, noHeader: opts.NoHeader, lines: opts.Format == FormatNDJSON}
+	if opts.Format != FormatJSON && opts.Format != FormatNDJSON {
+		// The report has its own columns, not the ones of the query
+		writer, err := newRowWriter(output, nil, opts)
+		if err != nil {
+			return nil, err
+		}
+		w.writer = writer
+	}
+	for i, c := range columnSchemas(types) {
+		w.columns = append(w.columns, &columnProfile{Column: c.Name, Type: c.TypeName(), numeric: isNumericColumn(types[i])})
+	}
+	return w, nil
+}
+
+// WriteHeader keeps the column names, which may have been renamed
+func (w *profileWriter) WriteHeader(columns []string) error {
+	for i, c := range columns {
+		w.columns[i].Column = c
+	}
+	return nil
+}
+
+func (w *profileWriter) WriteRow(values []sql.NullString) error {
+	for i, v := range values {
+		w.columns[i].add(v)
+	}
+	return nil
+}
+
+func (w *profileWriter) Flush() (err error) {
+	for _, p := range w.columns {
+		p.finish()
+	}
+	if w.writer == nil {
+		return w.writeJSON()
+	}
+	if !w.noHeader {
+		if err = w.writer.WriteHeader(profileHeader); err != nil {
+			return
+		}
+	}
+	for _, p := range w.columns {
+		if err = w.writer.WriteRow(p.values()); err != nil {
+			return
+		}
+	}
+	return w.writer.Flush()
+}
+
+func (w *profileWriter) writeJSON() (err error) {
+	if !w.lines {
+		data, err := json.MarshalIndent(w.columns, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = w.output.Write(append(data, '\n'))
+		return err
+	}
+	encoder := json.NewEncoder(w.output)
+	for _, p := range w.columns {
+		if err = encoder.Encode(p); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,48 @@
+package export
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Replacement replaces every match of Pattern in a value. With is expanded
+// like regexp.ReplaceAllString, so it can refer to groups like $1, unless
+// Literal is set.
+type Replacement struct {
+	Pattern *regexp.Regexp
+	With    string
+	Literal bool
+}
+
+func (r Replacement) replace(s string) string {
+	if r.Literal {
+		return r.Pattern.ReplaceAllLiteralString(s, r.With)
+	}
+	return r.Pattern.ReplaceAllString(s, r.With)
+}
+
+// ParseReplacements parses --replace and --replace-regex values in the form
+// old=new, splitting on the first =. The literal replacements are applied
+// first, then the regular expressions, each in the order they were given.
+func ParseReplacements(literal, regex []string) (replacements []Replacement, err error) {
+	for _, v := range literal {
+		old, with, ok := strings.Cut(v, "=")
+		if !ok || old == "" {
+			return nil, fmt.Errorf("Invalid --replace %q, expected old=new", v)
+		}
+		replacements = append(replacements, Replacement{Pattern: regexp.MustCompile(regexp.QuoteMeta(old)), With: with, Literal: true})
+	}
+	for _, v := range regex {
+		pattern, with, ok := strings.Cut(v, "=")
+		if !ok || pattern == "" {
+			return nil, fmt.Errorf("Invalid --replace-regex %q, expected pattern=replacement", v)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid --replace-regex %q: %w", v, err)
+		}
+		replacements = append(replacements, Replacement{Pattern: re, With: with})
+	}
+	return
+}
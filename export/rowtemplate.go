@@ -0,0 +1,85 @@
+package export
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// templateFuncs are the functions available to row templates on top of the
+// text/template builtins like printf
+var templateFuncs = template.FuncMap{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	// default returns def when the value is empty or NULL, e.g.
+	// {{.name | default "unknown"}}
+	"default": func(def string, value any) any {
+		if s, ok := value.(string); !ok || s == "" {
+			return def
+		}
+		return value
+	},
+}
+
+// ParseRowTemplate parses a --template so a mistake in it fails before any
+// query runs. Referring to a column that isn't in the result set is an error.
+func ParseRowTemplate(text string) (*template.Template, error) {
+	t, err := template.New("row").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid template: %w", err)
+	}
+	return t, nil
+}
+
+// templateWriter renders every row through a text/template. The columns are
+// available by name as strings, with NULL as an empty string. A line break
+// is added after each row unless the template ends with one.
+type templateWriter struct {
+	output  io.Writer
+	tmpl    *template.Template
+	newline bool
+	columns []string
+	buf     bytes.Buffer
+}
+
+func newTemplateWriter(output io.Writer, types []*sql.ColumnType, text string) (*templateWriter, error) {
+	tmpl, err := ParseRowTemplate(text)
+	if err != nil {
+		return nil, err
+	}
+	w := &templateWriter{output: output, tmpl: tmpl, newline: !strings.HasSuffix(text, "\n")}
+	for _, t := range types {
+		w.columns = append(w.columns, t.Name())
+	}
+	return w, nil
+}
+
+// WriteHeader only replaces the column names, which may have been renamed,
+// since the template decides what each line contains
+func (w *templateWriter) WriteHeader(columns []string) error {
+	w.columns = columns
+	return nil
+}
+
+func (w *templateWriter) WriteRow(values []sql.NullString) error {
+	row := make(map[string]any, len(values))
+	for i, v := range values {
+		row[w.columns[i]] = v.String
+	}
+	w.buf.Reset()
+	if err := w.tmpl.Execute(&w.buf, row); err != nil {
+		return err
+	}
+	if w.newline {
+		w.buf.WriteByte('\n')
+	}
+	_, err := w.output.Write(w.buf.Bytes())
+	return err
+}
+
+func (w *templateWriter) Flush() error {
+	return nil
+}
@@ -0,0 +1,33 @@
+package export
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestTemplateDedupeHeaders(t *testing.T) {
+	set := testResultSet{columns: []string{"id", "name", "id"}, rows: [][]string{{"1", "ann", "7"}}}
+	var b bytes.Buffer
+	open := func() (io.WriteCloser, error) { return NopCloser{&b}, nil }
+	opts := WriteOptions{Format: FormatTemplate, Template: "{{.id}} {{.name}} {{.id_2}}", DedupeHeaders: true}
+	if _, err := writeResultSet(testRows(t, set), open, opts); err != nil {
+		t.Fatal(err)
+	}
+	if want := "1 ann 7\n"; b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
+
+func TestTemplateErrorRowNumber(t *testing.T) {
+	open := func() (io.WriteCloser, error) { return NopCloser{io.Discard}, nil }
+	opts := WriteOptions{Format: FormatTemplate, Template: `{{if eq .id "2"}}{{.missing}}{{end}}`}
+	_, err := writeResultSet(testRows(t, numberedRows(3)), open, opts)
+	if err == nil {
+		t.Fatal("got no error for a column that isn't in the result set")
+	}
+	if !strings.HasPrefix(err.Error(), "row 2: ") || strings.Count(err.Error(), "row 2: ") != 1 {
+		t.Errorf("got error %q, want it to start with a single row 2: ", err)
+	}
+}
@@ -0,0 +1,168 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func IsS3Path(filename string) bool {
+	return strings.HasPrefix(filename, "s3://")
+}
+
+// S3Destination creates multipart uploads for s3:// outputs and keeps track of
+// every object it has uploaded so they can be summarized at the end of a run
+type S3Destination struct {
+	Context context.Context
+	Region  string
+
+	mu       sync.Mutex
+	cfg      *aws.Config
+	clients  map[string]*s3.Client
+	uploaded []S3Object
+}
+
+type S3Object struct {
+	Bucket string
+	Key    string
+	Size   int64
+}
+
+func (o S3Object) String() string {
+	return fmt.Sprintf("s3://%s/%s", o.Bucket, o.Key)
+}
+
+func ParseS3Path(s3Path string) (bucket, key string, err error) {
+	// The path isn't parsed as a URL because the output template may contain
+	// verbs like %03d that aren't valid escape sequences
+	bucket, key, _ = strings.Cut(strings.TrimPrefix(s3Path, "s3://"), "/")
+	if bucket == "" {
+		return "", "", fmt.Errorf("The S3 path %s must include a bucket", s3Path)
+	}
+	if key == "" || strings.HasSuffix(key, "/") {
+		return "", "", fmt.Errorf("The S3 path %s must include an object key, not just a prefix", s3Path)
+	}
+	return
+}
+
+// Create starts a multipart upload to the given s3:// path. Everything written
+// to the returned writer is streamed to S3 and the upload completes on Close.
+// The object gets the contentType, and the contentEncoding unless it's empty.
+func (d *S3Destination) Create(s3Path, contentType, contentEncoding string) (output io.WriteCloser, err error) {
+	bucket, key, err := ParseS3Path(s3Path)
+	if err != nil {
+		return
+	}
+	client, err := d.client(bucket)
+	if err != nil {
+		return
+	}
+	pr, pw := io.Pipe()
+	w := &s3Writer{
+		pw:     pw,
+		done:   make(chan error, 1),
+		object: S3Object{Bucket: bucket, Key: key},
+		dest:   d,
+	}
+	uploader := manager.NewUploader(client)
+	go func() {
+		input := &s3.PutObjectInput{
+			Bucket:      aws.String(bucket),
+			Key:         aws.String(key),
+			Body:        pr,
+			ContentType: aws.String(contentType),
+		}
+		if contentEncoding != "" {
+			input.ContentEncoding = aws.String(contentEncoding)
+		}
+		_, err := uploader.Upload(d.Context, input)
+		// Unblock any pending writes if the upload failed
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w, nil
+}
+
+// client returns an S3 client for the region the bucket lives in. The region
+// is looked up once per bucket if it wasn't provided explicitly.
+func (d *S3Destination) client(bucket string) (client *s3.Client, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if client, ok := d.clients[bucket]; ok {
+		return client, nil
+	}
+	if d.cfg == nil {
+		cfg, err := config.LoadDefaultConfig(d.Context, config.WithRegion(d.Region))
+		if err != nil {
+			return nil, fmt.Errorf("Error loading AWS config: %w", err)
+		}
+		d.cfg = &cfg
+		d.clients = make(map[string]*s3.Client)
+	}
+	cfg := d.cfg.Copy()
+	if cfg.Region == "" {
+		region, err := manager.GetBucketRegion(d.Context, s3.NewFromConfig(cfg, func(o *s3.Options) {
+			o.Region = "us-east-1"
+		}), bucket)
+		if err != nil {
+			return nil, fmt.Errorf("Error looking up the region for bucket %s: %w", bucket, err)
+		}
+		cfg.Region = region
+	}
+	client = s3.NewFromConfig(cfg)
+	d.clients[bucket] = client
+	return
+}
+
+func (d *S3Destination) completed(o S3Object) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.uploaded = append(d.uploaded, o)
+}
+
+// LogSummary logs the key and size of every completed upload
+func (d *S3Destination) LogSummary() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, o := range d.uploaded {
+		slog.Info(fmt.Sprintf("uploaded %s (%d bytes)", o, o.Size), "file", o.String(), "bytes", o.Size)
+	}
+}
+
+type s3Writer struct {
+	pw     *io.PipeWriter
+	done   chan error
+	object S3Object
+	dest   *S3Destination
+}
+
+func (w *s3Writer) Write(p []byte) (n int, err error) {
+	n, err = w.pw.Write(p)
+	w.object.Size += int64(n)
+	return
+}
+
+// Close finishes the upload and waits for S3 to acknowledge it
+func (w *s3Writer) Close() error {
+	w.pw.Close()
+	if err := <-w.done; err != nil {
+		return fmt.Errorf("Error uploading %s: %w", w.object, err)
+	}
+	w.dest.completed(w.object)
+	return nil
+}
+
+// Abort fails the upload. The uploader aborts the multipart upload when its
+// body returns an error so no orphaned parts are left behind.
+func (w *s3Writer) Abort(err error) {
+	w.pw.CloseWithError(err)
+	<-w.done
+}
@@ -0,0 +1,43 @@
+package export
+
+import (
+	"database/sql"
+	"math/rand/v2"
+)
+
+// sampleWriter keeps a uniform random sample of the rows written to it using
+// reservoir sampling and writes them to the underlying writer when flushed.
+// Only the sample is held in memory. The rows aren't kept in their original
+// order.
+type sampleWriter struct {
+	RowWriter
+	reservoir [][]sql.NullString
+	size      int
+	seen      int
+	rng       *rand.Rand
+}
+
+func newSampleWriter(w RowWriter, size int, seed uint64) *sampleWriter {
+	return &sampleWriter{RowWriter: w, size: size, rng: rand.New(rand.NewPCG(seed, seed))}
+}
+
+func (w *sampleWriter) WriteRow(values []sql.NullString) error {
+	w.seen++
+	if len(w.reservoir) < w.size {
+		w.reservoir = append(w.reservoir, append([]sql.NullString(nil), values...))
+		return nil
+	}
+	if i := w.rng.IntN(w.seen); i < w.size {
+		copy(w.reservoir[i], values)
+	}
+	return nil
+}
+
+func (w *sampleWriter) Flush() error {
+	for _, row := range w.reservoir {
+		if err := w.RowWriter.WriteRow(row); err != nil {
+			return err
+		}
+	}
+	return w.RowWriter.Flush()
+}
@@ -0,0 +1,118 @@
+package export
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// ColumnSchema describes a result set column using the metadata reported by
+// the driver. Fields the driver doesn't report are omitted.
+type ColumnSchema struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Nullable  *bool  `json:"nullable,omitempty"`
+	Length    *int64 `json:"length,omitempty"`
+	Precision *int64 `json:"precision,omitempty"`
+	Scale     *int64 `json:"scale,omitempty"`
+}
+
+// String formats the column like a MySQL column definition, e.g.
+// VARCHAR(255) NOT NULL
+func (c ColumnSchema) String() string {
+	s := c.TypeName()
+	if c.Nullable != nil && !*c.Nullable {
+		s += " NOT NULL"
+	}
+	return s
+}
+
+// TypeName is the type of the column with its precision or length when the
+// driver reports them, e.g. DECIMAL(10,2) or DATETIME(3). The mysql driver
+// doesn't report the length of string columns.
+func (c ColumnSchema) TypeName() string {
+	switch {
+	case c.Precision != nil && c.Type == "DECIMAL":
+		return fmt.Sprintf("%s(%d,%d)", c.Type, *c.Precision, *c.Scale)
+	case c.Scale != nil && *c.Scale > 0 && (c.Type == "DATETIME" || c.Type == "TIMESTAMP" || c.Type == "TIME"):
+		// The scale of temporal columns is their fractional seconds
+		return fmt.Sprintf("%s(%d)", c.Type, *c.Scale)
+	case c.Length != nil:
+		return fmt.Sprintf("%s(%d)", c.Type, *c.Length)
+	}
+	return c.Type
+}
+
+func columnSchemas(types []*sql.ColumnType) []ColumnSchema {
+	columns := make([]ColumnSchema, len(types))
+	for i, t := range types {
+		columns[i] = ColumnSchema{Name: t.Name(), Type: t.DatabaseTypeName()}
+		if nullable, ok := t.Nullable(); ok {
+			columns[i].Nullable = &nullable
+		}
+		if length, ok := t.Length(); ok {
+			columns[i].Length = &length
+		}
+		if precision, scale, ok := t.DecimalSize(); ok {
+			columns[i].Precision = &precision
+			columns[i].Scale = &scale
+		}
+	}
+	return columns
+}
+
+type resultSetSchema struct {
+	ResultSet int            `json:"result_set"`
+	File      string         `json:"file,omitempty"`
+	Database  string         `json:"database,omitempty"`
+	Table     string         `json:"table,omitempty"`
+	Columns   []ColumnSchema `json:"columns"`
+}
+
+// WriteSchemaFile writes the columns of every result set to filename as JSON
+func WriteSchemaFile(filename string, results []Result) error {
+	schemas := make([]resultSetSchema, len(results))
+	for i, r := range results {
+		schemas[i] = resultSetSchema{
+			ResultSet: r.Index,
+			File:      r.File,
+			Database:  r.Query.Database,
+			Table:     r.Query.Table,
+			Columns:   r.Columns,
+		}
+	}
+	data, err := json.MarshalIndent(schemas, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// writeColumnTypes writes the columns of a result set to a .types.json file
+// next to its output. The columns of a result set written to stdout are logged
+// to stderr instead.
+func writeColumnTypes(data OutputData, res Result) (err error) {
+	if res.File == "" {
+		if data.OutputTemplate == "" {
+			for _, c := range res.Columns {
+				slog.Info(fmt.Sprintf("column %s: %s", c.Name, c), "result_set", res.Index, "column", c)
+			}
+		}
+		return
+	}
+	contents, err := json.MarshalIndent(res.Columns, "", "  ")
+	if err != nil {
+		return
+	}
+	sidecar, err := openDestination(data, res.File+".types.json")
+	if err != nil {
+		return
+	}
+	_, err = sidecar.Write(append(contents, '\n'))
+	if cerr := sidecar.Close(); err == nil {
+		err = cerr
+	}
+	return
+}
@@ -0,0 +1,101 @@
+package export
+
+import "strings"
+
+// SplitStatements splits a script into its individual statements on the
+// semicolons that aren't inside of a string, quoted identifier or comment.
+// Empty statements are dropped.
+func SplitStatements(script string) (statements []string) {
+	start := 0
+	add := func(end int) {
+		if stmt := strings.TrimSpace(script[start:end]); stmt != "" {
+			statements = append(statements, stmt)
+		}
+		start = end + 1
+	}
+	scanSQL(script, func(i int) {
+		if script[i] == ';' {
+			add(i)
+		}
+	})
+	add(len(script))
+	return
+}
+
+// scanSQL calls visit with the index of each byte of the script that isn't
+// part of a string, quoted identifier or comment
+func scanSQL(script string, visit func(i int)) {
+	var quote byte
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		if quote != 0 {
+			switch {
+			case c == '\\' && quote != '`':
+				i++
+			case c == quote:
+				quote = 0
+			}
+			continue
+		}
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '#' || strings.HasPrefix(script[i:], "-- ") || strings.HasPrefix(script[i:], "--\t") || strings.HasPrefix(script[i:], "--\n"):
+			if end := strings.IndexByte(script[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(script)
+			}
+		case strings.HasPrefix(script[i:], "/*"):
+			if end := strings.Index(script[i+2:], "*/"); end >= 0 {
+				i += end + 3
+			} else {
+				i = len(script)
+			}
+		default:
+			visit(i)
+		}
+	}
+}
+
+// sqlWord is a keyword or name in a statement, upper cased, and where it
+// starts
+type sqlWord struct {
+	word  string
+	start int
+}
+
+// topLevelWords returns the words of the statement that aren't inside of
+// parentheses, strings, quoted identifiers or comments, which are the
+// clauses of the statement itself rather than of its subqueries
+func topLevelWords(stmt string) (words []sqlWord) {
+	depth, start, prev := 0, -1, -1
+	end := func() {
+		if start >= 0 {
+			words = append(words, sqlWord{word: strings.ToUpper(stmt[start : prev+1]), start: start})
+			start = -1
+		}
+	}
+	scanSQL(stmt, func(i int) {
+		// Anything skipped in between, like a comment, ends the word
+		if i != prev+1 {
+			end()
+		}
+		c := stmt[i]
+		isWord := c == '_' || c == '$' || c == '@' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+		if !isWord {
+			end()
+		}
+		switch {
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case isWord && depth == 0 && start < 0:
+			start = i
+		}
+		prev = i
+	})
+	end()
+	return
+}
@@ -0,0 +1,98 @@
+package export
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// quoteIdentifier escapes a table or column name so it can be used in a query
+// even if it is a reserved word or contains backticks
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func currentDatabase(ctx context.Context, db *sql.DB) (string, error) {
+	var database sql.NullString
+	if err := db.QueryRowContext(ctx, "SELECT DATABASE()").Scan(&database); err != nil {
+		return "", err
+	}
+	if !database.Valid {
+		return "", fmt.Errorf("A database must be selected to export tables")
+	}
+	return database.String, nil
+}
+
+// CheckTablesExist returns an error naming the first table that isn't in the
+// current database
+func CheckTablesExist(ctx context.Context, db *sql.DB, tables []string) (err error) {
+	database, err := currentDatabase(ctx, db)
+	if err != nil {
+		return
+	}
+	for _, table := range tables {
+		var exists bool
+		err = db.QueryRowContext(ctx,
+			"SELECT COUNT(*) > 0 FROM information_schema.tables WHERE table_schema = ? AND table_name = ?",
+			database, table,
+		).Scan(&exists)
+		if err != nil {
+			return
+		}
+		if !exists {
+			return fmt.Errorf("The table %s does not exist in %s", table, database)
+		}
+	}
+	return
+}
+
+// ListTables returns every table in the current database that doesn't match
+// one of the exclude patterns
+func ListTables(ctx context.Context, db *sql.DB, exclude []string, skipViews bool) (tables []string, err error) {
+	database, err := currentDatabase(ctx, db)
+	if err != nil {
+		return
+	}
+	query := "SELECT table_name FROM information_schema.tables WHERE table_schema = ?"
+	if skipViews {
+		query += " AND table_type = 'BASE TABLE'"
+	}
+	rows, err := db.QueryContext(ctx, query+" ORDER BY table_name", database)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table string
+		if err = rows.Scan(&table); err != nil {
+			return
+		}
+		if !matchesAny(exclude, table) {
+			tables = append(tables, table)
+		}
+	}
+	return tables, rows.Err()
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// TableQueries returns a query selecting every row of each table
+func TableQueries(tables []string, where string) (queries []Query) {
+	for _, table := range tables {
+		sql := "SELECT * FROM " + quoteIdentifier(table)
+		if where != "" {
+			sql += " WHERE " + where
+		}
+		queries = append(queries, Query{SQL: sql, Table: table})
+	}
+	return
+}
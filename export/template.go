@@ -0,0 +1,143 @@
+package export
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// templateToken matches the numeric %d and %0Nd verbs, the %s of the
+// partition, an escaped %% and the named {placeholder} tokens of the output
+// template
+var templateToken = regexp.MustCompile(`%(0\d+)?d|%s|%%|\{(\w+)\}`)
+
+// unsafePathChars matches the characters that can't be part of a file name
+// on the common file systems, including the path separators
+var unsafePathChars = regexp.MustCompile(`[/\\:*?"<>|\x00-\x1f]`)
+
+// placeholders are the named tokens the output template can contain
+var placeholders = []string{"{setnum}", "{table}", "{database}", "{host}", "{date}", "{time}", "{query_hash}"}
+
+// urlEscape matches a percent-encoded byte in an S3 or HTTP output
+var urlEscape = regexp.MustCompile(`^%[0-9A-Fa-f]{2}`)
+
+// outputFilename returns the file the output template expands to. An empty
+// filename means the output is written to stdout.
+func outputFilename(data OutputData) string {
+	return templateToken.ReplaceAllStringFunc(data.OutputTemplate, func(token string) string {
+		switch token {
+		case "%%":
+			return "%"
+		case "%s":
+			return data.Partition
+		case "{setnum}":
+			return strconv.Itoa(data.FileNum)
+		case "{table}":
+			return pathSafe(data.Table)
+		case "{database}":
+			return pathSafe(data.Database)
+		case "{host}":
+			return pathSafe(data.Host)
+		case "{date}":
+			return data.Started.Format("20060102")
+		case "{time}":
+			return data.Started.Format("150405")
+		case "{query_hash}":
+			return queryHash(data.Query)
+		}
+		if token[0] == '%' {
+			return fmt.Sprintf(token, data.FileNum)
+		}
+		// ValidateOutputTemplate rejects unknown placeholders, leave them
+		// alone if it wasn't called
+		return token
+	})
+}
+
+// pathSafe replaces the characters of a placeholder value that would change
+// the directory the file is written to or aren't allowed in a file name
+func pathSafe(value string) string {
+	value = unsafePathChars.ReplaceAllString(value, "_")
+	if value == "." || value == ".." {
+		return "_"
+	}
+	return value
+}
+
+func queryHash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])[:8]
+}
+
+// OutputCreatesMultipleFiles reports whether the output template has a
+// placeholder that changes with every result set
+func OutputCreatesMultipleFiles(outputTemplate string) bool {
+	for _, token := range templateToken.FindAllString(outputTemplate, -1) {
+		if token[0] == '%' && token != "%%" || token == "{setnum}" || token == "{table}" {
+			return true
+		}
+	}
+	return false
+}
+
+// OutputHasDatabase reports whether the output template has the {database}
+// placeholder
+func OutputHasDatabase(outputTemplate string) bool {
+	return outputHas(outputTemplate, "{database}")
+}
+
+// OutputHasHost reports whether the output template has the {host}
+// placeholder
+func OutputHasHost(outputTemplate string) bool {
+	return outputHas(outputTemplate, "{host}")
+}
+
+// OutputHasPartition reports whether the output template has the %s that
+// PartitionBy fills in
+func OutputHasPartition(outputTemplate string) bool {
+	return outputHas(outputTemplate, "%s")
+}
+
+func outputHas(outputTemplate, token string) bool {
+	return slices.Contains(templateToken.FindAllString(outputTemplate, -1), token)
+}
+
+// ValidateOutputTemplate checks that every % in the template starts a %d or
+// %0Nd verb, is the %s of the partition or is escaped as %%, that there's
+// at most one numeric verb and that every {placeholder} is known.
+// S3 and HTTP outputs can also contain percent-encoded bytes such as %20.
+func ValidateOutputTemplate(outputTemplate string) error {
+	for _, token := range templateToken.FindAllString(outputTemplate, -1) {
+		if token[0] == '{' && indexOf(placeholders, token) < 0 {
+			return fmt.Errorf("Invalid output template %q: unknown placeholder %s, expected one of %s", outputTemplate, token, strings.Join(placeholders, ", "))
+		}
+	}
+	isURL := IsS3Path(outputTemplate) || IsHTTPPath(outputTemplate)
+	verbs := 0
+	for i := 0; i < len(outputTemplate); i++ {
+		if outputTemplate[i] != '%' {
+			continue
+		}
+		rest := outputTemplate[i:]
+		token := templateToken.FindString(rest)
+		switch {
+		case token != "" && strings.HasPrefix(rest, token) && token[0] == '%':
+			if token != "%%" && token != "%s" {
+				verbs++
+			}
+			i += len(token) - 1
+		case isURL && urlEscape.MatchString(rest):
+			i += 2
+		default:
+			return fmt.Errorf("Invalid output template %q: unexpected %% at position %d, use %%%% for a literal %% or %%d for the result set number", outputTemplate, i+1)
+		}
+	}
+	if verbs > 1 {
+		return fmt.Errorf("Invalid output template %q: only one %%d can be used", outputTemplate)
+	}
+	return nil
+}
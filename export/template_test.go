@@ -0,0 +1,71 @@
+package export
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateOutputTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		// err is part of the error, or empty if the template is valid
+		err string
+	}{
+		{"export.csv", ""},
+		{"export-%d.csv", ""},
+		{"export-%05d.csv", ""},
+		{"monthly%%report-%d.csv", ""},
+		{"100%%.csv", ""},
+		// %s is the partition, the command rejects it without --partition-by
+		{"region-%s-%d.csv", ""},
+		{"{database}/{table}-{setnum}.csv", ""},
+		{"s3://bucket/my%20exports/export-%d.csv", ""},
+		{"https://example.com/upload?name=a%2Fb-%d.csv", ""},
+		{"export-%d-%d.csv", "only one %d"},
+		{"export-%d-%05d.csv", "only one %d"},
+		{"export-%x.csv", "unexpected % at position 8"},
+		{"monthly%report-%d.csv", "unexpected % at position 8"},
+		{"export-%5d.csv", "unexpected % at position 8"},
+		{"export-%.csv", "unexpected % at position 8"},
+		{"export-%", "unexpected % at position 8"},
+		{"my%20exports/export.csv", "unexpected % at position 3"},
+		{"export-{month}.csv", "unknown placeholder {month}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			err := ValidateOutputTemplate(tt.template)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("got error %q, want none", err)
+			case tt.err != "" && err == nil:
+				t.Errorf("got no error, want one containing %q", tt.err)
+			case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+				t.Errorf("got error %q, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestOutputFilename(t *testing.T) {
+	tests := []struct {
+		template, want string
+	}{
+		{"export.csv", "export.csv"},
+		{"export-%d.csv", "export-7.csv"},
+		{"export-%05d.csv", "export-00007.csv"},
+		{"monthly%%report-%d.csv", "monthly%report-7.csv"},
+		{"%%d-%d.csv", "%d-7.csv"},
+		{"region-%s-%d.csv", "region-eu-7.csv"},
+		{"{table}-{setnum}.csv", "orders-7.csv"},
+		{"s3://bucket/my%20exports/export-%d.csv", "s3://bucket/my%20exports/export-7.csv"},
+	}
+	data := OutputData{FileNum: 7, Partition: "eu", Table: "orders"}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			data.OutputTemplate = tt.template
+			if got := outputFilename(data); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package export
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// minThrottleSleep is the shortest wait. Shorter waits are added up until
+// they're worth sleeping for, so high rates aren't slowed down by the cost
+// of sleeping for every row.
+const minThrottleSleep = 10 * time.Millisecond
+
+// rateLimiter is a token bucket that limits how fast rows are read. It holds
+// a second's worth of rows so short bursts aren't slowed down. It's shared by
+// the exporters of a parallel export so the limit applies to all of them
+// together.
+type rateLimiter struct {
+	ctx    context.Context
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+	waited time.Duration
+}
+
+func newRateLimiter(ctx context.Context, rowsPerSecond int) *rateLimiter {
+	if rowsPerSecond <= 0 {
+		return nil
+	}
+	rate := float64(rowsPerSecond)
+	return &rateLimiter{ctx: ctx, rate: rate, tokens: rate, last: time.Now()}
+}
+
+// wait takes a row from the bucket, sleeping until there is one. It returns
+// early with the context's error if the export is cancelled.
+func (l *rateLimiter) wait() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate) - 1
+	l.last = now
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	if delay >= minThrottleSleep {
+		l.waited += delay
+	}
+	l.mu.Unlock()
+	if delay < minThrottleSleep {
+		return nil
+	}
+	select {
+	case <-l.ctx.Done():
+		return l.ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// throttled returns the total time spent waiting
+func (l *rateLimiter) throttled() time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.waited
+}
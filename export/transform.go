@@ -0,0 +1,87 @@
+package export
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// valueTransform changes the value of a single field before it is written.
+// Transforms are only called for values that aren't NULL.
+type valueTransform func(column int, v sql.NullString) (sql.NullString, error)
+
+// valueTransforms returns the transforms enabled by opts in the order they
+// are applied. NULL sentinels are checked first. Masks come after the built
+// in transforms so nothing is derived from the original value after it has
+// been redacted. Replacements run last so they see the value that would
+// otherwise be written.
+func valueTransforms(columns []string, types []*sql.ColumnType, opts WriteOptions) (transforms []valueTransform, err error) {
+	// Sentinel values are matched against what MySQL sent before anything
+	// else changes them
+	if t := nullIfTransform(columns, opts.NullIfs); t != nil {
+		transforms = append(transforms, t)
+	}
+	if opts.GeometryFormat != "" {
+		isGeometry := make([]bool, len(types))
+		for i, t := range types {
+			isGeometry[i] = t.DatabaseTypeName() == "GEOMETRY"
+		}
+		transforms = append(transforms, func(column int, v sql.NullString) (sql.NullString, error) {
+			if !isGeometry[column] {
+				return v, nil
+			}
+			s, err := convertGeometry([]byte(v.String), opts.GeometryFormat)
+			return sql.NullString{String: s, Valid: true}, err
+		})
+	}
+	if opts.TrimSpace {
+		transforms = append(transforms, func(_ int, v sql.NullString) (sql.NullString, error) {
+			v.String = strings.TrimSpace(v.String)
+			return v, nil
+		})
+	}
+	if opts.ReplaceNewlines {
+		r := strings.NewReplacer("\r\n", opts.NewlineReplacement, "\r", opts.NewlineReplacement, "\n", opts.NewlineReplacement)
+		transforms = append(transforms, func(_ int, v sql.NullString) (sql.NullString, error) {
+			v.String = r.Replace(v.String)
+			return v, nil
+		})
+	}
+	masks, err := masksFor(columns, opts.Masks)
+	if err != nil {
+		return
+	}
+	if masks != nil {
+		transforms = append(transforms, func(column int, v sql.NullString) (sql.NullString, error) {
+			if masks[column] != nil {
+				v.String = maskValue(*masks[column], opts.MaskSalt, v.String)
+			}
+			return v, nil
+		})
+	}
+	if len(opts.Replacements) > 0 {
+		transforms = append(transforms, func(_ int, v sql.NullString) (sql.NullString, error) {
+			for _, r := range opts.Replacements {
+				v.String = r.replace(v.String)
+			}
+			return v, nil
+		})
+	}
+	return
+}
+
+// applyTransforms runs every transform over the values. Errors name the
+// column that couldn't be transformed.
+func applyTransforms(transforms []valueTransform, columns []string, values []sql.NullString) (err error) {
+	for _, t := range transforms {
+		for i, v := range values {
+			if !v.Valid {
+				continue
+			}
+			if values[i], err = t(i, v); err != nil {
+				return fmt.Errorf("column %s: %w", columns[i], err)
+			}
+		}
+	}
+	return
+}
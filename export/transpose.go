@@ -0,0 +1,54 @@
+package export
+
+import (
+	"database/sql"
+	"io"
+)
+
+// transposeHeader is the header of a transposed result set
+var transposeHeader = []string{"field", "value"}
+
+// transposeWriter writes every row as one field, value pair per column
+// instead of a single wide row. Rows are separated by a blank line and the
+// header is only written once at the top.
+type transposeWriter struct {
+	RowWriter
+	output  io.Writer
+	columns []string
+	rows    int
+}
+
+func newTransposeWriter(w RowWriter, output io.Writer, types []*sql.ColumnType) *transposeWriter {
+	t := &transposeWriter{RowWriter: w, output: output}
+	for _, c := range types {
+		t.columns = append(t.columns, c.Name())
+	}
+	return t
+}
+
+// WriteHeader keeps the column names, which may have been renamed, and writes
+// the field, value header
+func (w *transposeWriter) WriteHeader(columns []string) error {
+	w.columns = columns
+	return w.RowWriter.WriteHeader(transposeHeader)
+}
+
+func (w *transposeWriter) WriteRow(values []sql.NullString) (err error) {
+	if w.rows > 0 {
+		// The row writers buffer their output so it has to be flushed before
+		// the blank line can be written after it
+		if err = w.RowWriter.Flush(); err != nil {
+			return
+		}
+		if _, err = io.WriteString(w.output, "\n"); err != nil {
+			return
+		}
+	}
+	w.rows++
+	for i, v := range values {
+		if err = w.RowWriter.WriteRow([]sql.NullString{{String: w.columns[i], Valid: true}, v}); err != nil {
+			return
+		}
+	}
+	return
+}
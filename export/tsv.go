@@ -0,0 +1,62 @@
+package export
+
+import (
+	"bufio"
+	"database/sql"
+	"io"
+	"strings"
+)
+
+// tsvEscaper escapes values the same way as mysql --batch so tabs and line
+// breaks inside of a value can't be confused with the separators
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\x00", `\0`)
+
+// tsvWriter writes tab separated values without any quoting. NULL is written
+// as NULL like the mysql client does, or as \N like SELECT ... INTO OUTFILE
+// for the mysql format.
+type tsvWriter struct {
+	w    *bufio.Writer
+	null string
+}
+
+func newTSVWriter(output io.Writer, null string) *tsvWriter {
+	return &tsvWriter{w: bufio.NewWriter(output), null: null}
+}
+
+func (w *tsvWriter) WriteHeader(columns []string) error {
+	record := make([]string, len(columns))
+	for i, c := range columns {
+		record[i] = tsvEscaper.Replace(c)
+	}
+	return w.write(record)
+}
+
+func (w *tsvWriter) WriteRow(values []sql.NullString) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		record[i] = w.null
+		if v.Valid {
+			record[i] = tsvEscaper.Replace(v.String)
+		}
+	}
+	return w.write(record)
+}
+
+// write writes a record of fields that have already been escaped
+func (w *tsvWriter) write(record []string) (err error) {
+	for i, field := range record {
+		if i > 0 {
+			if err = w.w.WriteByte('\t'); err != nil {
+				return
+			}
+		}
+		if _, err = w.w.WriteString(field); err != nil {
+			return
+		}
+	}
+	return w.w.WriteByte('\n')
+}
+
+func (w *tsvWriter) Flush() error {
+	return w.w.Flush()
+}
@@ -0,0 +1,47 @@
+package export
+
+import (
+	"bytes"
+	"database/sql"
+	"testing"
+)
+
+func TestTSVEscaping(t *testing.T) {
+	values := []sql.NullString{
+		{String: "tab\there", Valid: true},
+		{String: "line\nbreak", Valid: true},
+		{String: `back\slash`, Valid: true},
+		{String: "nul\x00", Valid: true},
+		{String: `\N`, Valid: true},
+		{String: "", Valid: true},
+		{},
+	}
+	tests := []struct {
+		format, want string
+	}{
+		{FormatTSV, "tab\\there\tline\\nbreak\tback\\\\slash\tnul\\0\t\\\\N\t\tNULL\n"},
+		{FormatMySQL, "tab\\there\tline\\nbreak\tback\\\\slash\tnul\\0\t\\\\N\t\t\\N\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var b bytes.Buffer
+			w, err := newRowWriter(&b, nil, WriteOptions{Format: tt.format})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = w.WriteHeader([]string{"a\tb", "c"}); err != nil {
+				t.Fatal(err)
+			}
+			if err = w.WriteRow(values); err != nil {
+				t.Fatal(err)
+			}
+			if err = w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			want := "a\\tb\tc\n" + tt.want
+			if b.String() != want {
+				t.Errorf("got %q, want %q", b.String(), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+)
+
+// verifyRows compares the number of rows exported with ExpectRows or the
+// count returned by ExpectRowsQuery. With SingleTransaction the query runs on
+// the connection of the snapshot so it sees the same data as the export.
+func (e *Exporter) verifyRows(ctx context.Context) (err error) {
+	if e.ExpectRows == nil && e.ExpectRowsQuery == "" {
+		return nil
+	}
+	var expected int
+	if e.ExpectRows != nil {
+		expected = *e.ExpectRows
+	} else if expected, err = e.countExpectedRows(ctx); err != nil {
+		return
+	}
+	exported := 0
+	for _, r := range e.Results {
+		exported += r.Rows
+	}
+	if exported == expected {
+		slog.Info(fmt.Sprintf("exported %d rows as expected", exported), "rows", exported)
+		return nil
+	}
+	removed := e.removeOutputs()
+	return fmt.Errorf("Exported %d rows but expected %d, removed %d output files", exported, expected, removed)
+}
+
+func (e *Exporter) countExpectedRows(ctx context.Context) (count int, err error) {
+	conn := e.conn
+	if conn == nil {
+		if conn, err = e.connect(ctx); err != nil {
+			return
+		}
+		defer conn.Close()
+	}
+	if err = conn.QueryRowContext(ctx, e.ExpectRowsQuery).Scan(&count); err != nil {
+		return 0, &QueryError{Query: e.ExpectRowsQuery, DSN: e.MaskedDSN, Err: fmt.Errorf("counting the expected rows: %w", err)}
+	}
+	return
+}
+
+// sidecarSuffixes are added to the name of an output for the files written
+// next to it
+var sidecarSuffixes = []string{".sha256", ".ctl", ".types.json", ".meta.json"}
+
+// removeOutputs removes the local files the export wrote, along with their
+// sidecar files, so a short export can't be picked up as if it were complete.
+// Uploads and stdout can't be taken back.
+func (e *Exporter) removeOutputs() (removed int) {
+	for _, r := range e.Results {
+		if r.File == "" {
+			continue
+		}
+		if !isLocalFile(r.File) {
+			slog.Warn(fmt.Sprintf("%s was already uploaded and can't be removed", r.File), "file", r.File)
+			continue
+		}
+		if err := os.Remove(r.File); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn(fmt.Sprintf("couldn't remove %s: %s", r.File, err), "file", r.File, "error", err)
+			continue
+		}
+		for _, sidecar := range sidecarSuffixes {
+			os.Remove(r.File + sidecar)
+		}
+		removed++
+	}
+	return
+}
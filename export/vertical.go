@@ -0,0 +1,66 @@
+package export
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// verticalWriter writes every row as a block of "column: value" lines like
+// the \G terminator of the mysql client. The column names are part of every
+// row so they're taken from the column types when no header is written.
+type verticalWriter struct {
+	output  io.Writer
+	columns []string
+	width   int
+	row     int
+}
+
+func newVerticalWriter(output io.Writer, types []*sql.ColumnType) *verticalWriter {
+	w := &verticalWriter{output: output}
+	columns := make([]string, len(types))
+	for i, t := range types {
+		columns[i] = t.Name()
+	}
+	w.setColumns(columns)
+	return w
+}
+
+func (w *verticalWriter) setColumns(columns []string) {
+	w.columns = columns
+	w.width = 0
+	for _, c := range columns {
+		w.width = max(w.width, utf8.RuneCountInString(c))
+	}
+}
+
+// WriteHeader only replaces the column names, which may have been renamed
+func (w *verticalWriter) WriteHeader(columns []string) error {
+	w.setColumns(columns)
+	return nil
+}
+
+func (w *verticalWriter) WriteRow(values []sql.NullString) error {
+	w.row++
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %d. row %s\n", strings.Repeat("*", 27), w.row, strings.Repeat("*", 27))
+	for i, v := range values {
+		value := v.String
+		if !v.Valid {
+			value = "NULL"
+		}
+		b.WriteString(strings.Repeat(" ", w.width-utf8.RuneCountInString(w.columns[i])))
+		b.WriteString(w.columns[i])
+		b.WriteString(": ")
+		b.WriteString(value)
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(w.output, b.String())
+	return err
+}
+
+func (w *verticalWriter) Flush() error {
+	return nil
+}
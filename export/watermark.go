@@ -0,0 +1,79 @@
+package export
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"os"
+	"strings"
+)
+
+// watermark tracks the largest value of a column. Numeric columns are
+// compared by value and everything else, including dates and times, as text.
+type watermark struct {
+	index   int
+	numeric bool
+	max     *string
+	maxRat  big.Rat
+}
+
+func newWatermark(columns []string, types []*sql.ColumnType, column string) (*watermark, error) {
+	i := indexOf(columns, column)
+	if i < 0 {
+		return nil, fmt.Errorf("the watermark column %s isn't in the result set", column)
+	}
+	return &watermark{index: i, numeric: isNumericColumn(types[i])}, nil
+}
+
+func (w *watermark) add(values []sql.NullString) error {
+	v := values[w.index]
+	if !v.Valid {
+		return nil
+	}
+	if !w.numeric {
+		if w.max == nil || v.String > *w.max {
+			w.max = &v.String
+		}
+		return nil
+	}
+	var r big.Rat
+	if _, ok := r.SetString(v.String); !ok {
+		return fmt.Errorf("the watermark %q isn't a number", v.String)
+	}
+	if w.max == nil || r.Cmp(&w.maxRat) > 0 {
+		w.max = &v.String
+		w.maxRat.Set(&r)
+	}
+	return nil
+}
+
+// sinceQuery wraps a single SELECT so it only returns the rows after the
+// watermark of the previous run
+func sinceQuery(query Query, column, since string) Query {
+	inner := strings.TrimRight(strings.TrimSpace(query.SQL), ";")
+	query.SQL = fmt.Sprintf("SELECT * FROM (%s) AS mysql2csv_since WHERE %s > ?", inner, quoteIdentifier(column))
+	query.Args = append(append([]any{}, query.Args...), since)
+	return query
+}
+
+// ReadWatermark reads the watermark saved by the previous run. It returns nil
+// on the first run, when the file doesn't exist yet.
+func ReadWatermark(filename string) (*string, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	since := strings.TrimRight(string(data), "\r\n")
+	return &since, nil
+}
+
+// WriteWatermark saves the watermark for the next run. The file is replaced
+// atomically so an interrupted run never leaves half a value behind.
+func WriteWatermark(filename, watermark string) error {
+	return writeFileAtomic(filename, []byte(watermark+"\n"))
+}
@@ -0,0 +1,542 @@
+package export
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// WriteOptions controls how a result set is written
+type WriteOptions struct {
+	NoHeader bool
+	Format   string
+	// QuoteChar is the character CSV fields are quoted with
+	QuoteChar string
+	// Quote is the CSV quoting mode, one of QuoteMinimal, QuoteAll or
+	// QuoteNone. The values of QuoteColumns are always quoted.
+	Quote        string
+	QuoteColumns []string
+	// EscapeChar escapes the comma, line breaks, NUL and itself inside of
+	// values with QuoteNone, like FIELDS ESCAPED BY of LOAD DATA. NULL is
+	// written as the escape character followed by N.
+	EscapeChar string
+	// QuoteEmpty quotes empty strings in CSV so they can be told apart from
+	// NULL, which is written as an empty field
+	QuoteEmpty bool
+	// Sample writes a random sample of this many rows instead of every row.
+	// SampleSeed seeds the sampling so the same seed picks the same rows from
+	// the same data.
+	Sample     int
+	SampleSeed uint64
+	// Footer is a line written after the last row of each output. {rows} is
+	// replaced with the number of rows and {sum:column} with the exact total
+	// of a column.
+	Footer string
+	// openFooter opens the file the footer is written to instead of the end of
+	// the output
+	openFooter func() (io.WriteCloser, error)
+	// CommentPrefix starts a line written before the header recording the
+	// query, when it was exported and the database it came from. No comment
+	// is written when it's empty.
+	CommentPrefix string
+	// comment is the text of the comment line for the current result set
+	comment string
+	// budget stops writing rows once MaxRowsTotal is reached
+	budget *rowBudget
+	// limiter slows down reading rows to MaxRowsPerSecond
+	limiter *rateLimiter
+	// encoding is the encoding of the output when characters it can't
+	// represent fail the export, so the error can name the row and column
+	encoding string
+	// Template is the text/template each row is rendered with by the template
+	// format
+	Template string
+	// TypesHeader writes a second header row with the MySQL type of each
+	// column. Only formats that write the header as a row, like csv and tsv,
+	// support it.
+	TypesHeader bool
+	// HeaderPrefix and HeaderSuffix are added to every column name in the
+	// header, which are also the JSON keys and template fields. Options that
+	// name columns still use the names from the query.
+	HeaderPrefix string
+	HeaderSuffix string
+	// Transpose writes each row as field, value pairs, one per column, with a
+	// blank line between rows. Only the csv and tsv formats support it.
+	Transpose bool
+	// Profile writes statistics of each column, such as how many values are
+	// NULL or distinct, instead of the rows. Only the csv, tsv, table, json
+	// and ndjson formats support it.
+	Profile bool
+	// DedupeHeaders renames repeated column names instead of warning about them
+	DedupeHeaders bool
+	// TruncateTableNames drops the table in front of column names like
+	// user.id, as the columnsWithAlias option of the driver adds, so only the
+	// column name is used
+	TruncateTableNames bool
+	// HeaderOnly writes the header of the result set without reading any
+	// of its rows
+	HeaderOnly bool
+	// SkipEmpty prevents the output from being opened at all when the result
+	// set has no rows
+	SkipEmpty bool
+	// KeyColumn is the column whose first and last values are recorded, as
+	// they were read from the database
+	KeyColumn string
+	// WatermarkColumn is the column whose largest value is recorded for the
+	// next incremental export
+	WatermarkColumn string
+	// Typed, DecimalAsNumber and BoolColumns control how values are typed by
+	// the JSON formats
+	Typed           bool
+	DecimalAsNumber bool
+	BoolColumns     []string
+	// JSONOmitNull leaves NULL values out of JSON objects instead of writing
+	// them as null
+	JSONOmitNull bool
+	// ReplaceNewlines replaces the line breaks in values with NewlineReplacement
+	TrimSpace          bool
+	ReplaceNewlines    bool
+	NewlineReplacement string
+	// GeometryFormat converts spatial columns to wkt or geojson
+	GeometryFormat string
+	Masks          []ColumnMask
+	MaskSalt       string
+	// NullIfs are values written as NULL, such as zero dates
+	NullIfs []NullIf
+	// Replacements are applied to every value after the other transforms
+	Replacements []Replacement
+	// BlobDir is where the values of BlobColumns are written, one file per
+	// value, named after BlobKey or a hash of the value. The cell gets the
+	// path of the file instead.
+	BlobDir     string
+	BlobColumns []string
+	BlobKey     string
+	// newBlobs creates the blobWriter for the result set once its output has
+	// been opened
+	newBlobs func(columns []string) (*blobWriter, error)
+	// PartitionBy writes the rows to a separate output for each value of the
+	// column, with %s in the output template replaced by the value
+	PartitionBy string
+	// openPartition opens the output of a partition. name is the value of the
+	// PartitionBy column made safe for a file name.
+	openPartition func(name string) (io.WriteCloser, error)
+	// SafeExcelPrefix is put in front of string values starting with =, +, -
+	// or @ so spreadsheets don't evaluate them as formulas. Nothing is
+	// changed when it's empty.
+	SafeExcelPrefix string
+}
+
+// Validate checks the options that can't be used together or with the
+// format. The errors name the command line flags of the options.
+func (opts WriteOptions) Validate() (err error) {
+	if err = ValidateFormat(opts.Format); err != nil {
+		return
+	}
+	format := opts.Format
+	if format == "" {
+		format = FormatCSV
+	}
+	jsonFormat := format == FormatJSON || format == FormatNDJSON
+	if opts.HeaderOnly {
+		if opts.NoHeader {
+			return fmt.Errorf("--header-only can't be used with --no-header or --paginate-column")
+		}
+		if jsonFormat || format == FormatVertical || format == FormatTemplate {
+			return fmt.Errorf("--header-only can only be used with the csv, tsv and table formats")
+		}
+	}
+	if format == FormatTemplate {
+		if opts.Template == "" {
+			return fmt.Errorf("--format template needs a --template or --template-file")
+		}
+		if _, err = ParseRowTemplate(opts.Template); err != nil {
+			return
+		}
+	} else if opts.Template != "" {
+		return fmt.Errorf("--template can only be used with --format template")
+	}
+	if format == FormatVertical && opts.NoHeader {
+		return fmt.Errorf("--no-header can't be used with --format vertical since every row is written with its column names")
+	}
+	if opts.CommentPrefix != "" && jsonFormat {
+		return fmt.Errorf("--comment can only be used with the csv, tsv, table and vertical formats")
+	}
+	if opts.TypesHeader && format != FormatCSV && format != FormatTSV && format != FormatMySQL {
+		return fmt.Errorf("--types-header can only be used with the csv, tsv and mysql formats")
+	}
+	if opts.Transpose {
+		if format != FormatCSV && format != FormatTSV {
+			return fmt.Errorf("--transpose can only be used with the csv and tsv formats, --format vertical shows one column per line in a terminal")
+		}
+		if opts.TypesHeader || len(opts.QuoteColumns) > 0 || opts.HeaderOnly {
+			return fmt.Errorf("--transpose can't be used with --types-header, --quote-columns or --header-only")
+		}
+	}
+	if opts.Profile {
+		switch format {
+		case FormatCSV, FormatTSV, FormatTable, FormatJSON, FormatNDJSON:
+		default:
+			return fmt.Errorf("--profile-columns can only be used with the csv, tsv, table, json and ndjson formats")
+		}
+		if opts.Transpose || opts.TypesHeader || opts.HeaderOnly || opts.Sample > 0 || opts.Footer != "" || opts.BlobDir != "" {
+			return fmt.Errorf("--profile-columns can't be used with --transpose, --types-header, --header-only, --sample, --footer or --blob-dir")
+		}
+	}
+	if opts.JSONOmitNull && !jsonFormat {
+		return fmt.Errorf("--json-omit-null can only be used with the json and ndjson formats")
+	}
+	if err = ValidateQuote(opts.Quote); err != nil {
+		return
+	}
+	if opts.Quote == QuoteNone && opts.QuoteEmpty {
+		return fmt.Errorf("--quote-empty can't be used with --quote none")
+	}
+	if opts.EscapeChar != "" {
+		if opts.Quote != QuoteNone {
+			return fmt.Errorf("--escape-char can only be used with --quote none")
+		}
+		if err = ValidateEscapeChar(opts.EscapeChar); err != nil {
+			return
+		}
+	}
+	if opts.QuoteChar != "" {
+		if err = ValidateQuoteChar(opts.QuoteChar); err != nil {
+			return
+		}
+	}
+	if err = ValidateGeometryFormat(opts.GeometryFormat); err != nil {
+		return
+	}
+	if opts.Footer != "" {
+		if err = ValidateFooter(opts.Footer); err != nil {
+			return
+		}
+	}
+	if opts.Sample < 0 {
+		return fmt.Errorf("--sample can't be negative")
+	}
+	if (opts.BlobDir == "") != (len(opts.BlobColumns) == 0) {
+		return fmt.Errorf("--blob-dir and --blob-column must be used together")
+	}
+	if opts.BlobKey != "" && opts.BlobDir == "" {
+		return fmt.Errorf("--blob-key can only be used with --blob-dir")
+	}
+	if opts.BlobDir != "" && opts.Sample > 0 {
+		return fmt.Errorf("--blob-dir can't be used with --sample")
+	}
+	if opts.PartitionBy != "" && (opts.Sample > 0 || opts.HeaderOnly || opts.BlobDir != "") {
+		return fmt.Errorf("--partition-by can't be used with --sample, --header-only, --blob-dir, --footer-file or --tee")
+	}
+	return
+}
+
+// resultRows are the parts of *sql.Rows the result sets are read through, so
+// the rows of several hosts can be written as one result set
+type resultRows interface {
+	Columns() ([]string, error)
+	ColumnTypes() ([]*sql.ColumnType, error)
+	Next() bool
+	Scan(dest ...any) error
+	NextResultSet() bool
+	Err() error
+}
+
+// writeResultSet writes every row of the current result set. The output is
+// only opened once the first row has been read so empty result sets can be
+// skipped.
+func writeResultSet(rows resultRows, open func() (io.WriteCloser, error), opts WriteOptions) (res Result, err error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return
+	}
+	if opts.TruncateTableNames {
+		columns = truncateTableNames(columns)
+	}
+	if dupes := duplicateColumns(columns); len(dupes) > 0 {
+		if opts.DedupeHeaders {
+			columns = dedupeColumns(columns)
+		} else {
+			slog.Warn(fmt.Sprintf("the result set has more than one column named %s, use --dedupe-headers to rename them", strings.Join(dupes, ", ")), "columns", dupes)
+		}
+	}
+	transforms, err := valueTransforms(columns, types, opts)
+	if err != nil {
+		return
+	}
+	if opts.SafeExcelPrefix != "" {
+		// Runs after every other transform so the value that is checked is
+		// the one that is written
+		transforms = append(transforms, safeExcelTransform(types, opts.SafeExcelPrefix, &res.Sanitized))
+	}
+	if opts.encoding != "" {
+		t, err := encodableTransform(opts.encoding)
+		if err != nil {
+			return res, err
+		}
+		if t != nil {
+			transforms = append(transforms, t)
+		}
+	}
+	var mark *watermark
+	if opts.WatermarkColumn != "" {
+		if mark, err = newWatermark(columns, types, opts.WatermarkColumn); err != nil {
+			return
+		}
+	}
+	footer, err := newFooter(opts.Footer, columns)
+	if err != nil {
+		return
+	}
+	hasRow := !opts.HeaderOnly && rows.Next()
+	if !hasRow {
+		if err = rows.Err(); err != nil {
+			return res, fmt.Errorf("reading row 1: %w", err)
+		}
+	}
+	if !hasRow && opts.SkipEmpty && !opts.HeaderOnly {
+		return
+	}
+	var writer RowWriter
+	var parts *partitions
+	var blobs *blobWriter
+	if opts.PartitionBy != "" {
+		// The output of each partition is opened when its first row is read
+		if parts, err = newPartitions(columns, types, opts); err != nil {
+			return
+		}
+		defer func() {
+			if cerr := parts.close(err); err == nil && cerr != nil {
+				err = outputError(cerr)
+			}
+		}()
+	} else {
+		var output io.WriteCloser
+		if output, err = open(); err != nil {
+			return
+		}
+		defer func() {
+			if a, ok := output.(Aborter); ok && err != nil {
+				a.Abort(err)
+				return
+			}
+			if cerr := output.Close(); err == nil && cerr != nil {
+				err = outputError(fmt.Errorf("closing the output: %w", cerr))
+			}
+		}()
+		if opts.newBlobs != nil {
+			if blobs, err = opts.newBlobs(columns); err != nil {
+				return
+			}
+		}
+		if writer, err = newOutputWriter(output, columns, types, footer, opts); err != nil {
+			return
+		}
+	}
+	values := make([]interface{}, len(columns))
+	stringVals := make([]sql.NullString, len(columns))
+	for i := range values {
+		values[i] = &sql.RawBytes{}
+	}
+	keyIndex := indexOf(columns, opts.KeyColumn)
+	if opts.Sample > 0 {
+		// The first and last rows read aren't necessarily in the sample
+		keyIndex = -1
+	}
+
+	for ; hasRow; hasRow = rows.Next() {
+		if !opts.budget.take() {
+			break
+		}
+		if err = opts.limiter.wait(); err != nil {
+			return
+		}
+		if err = rows.Scan(values...); err != nil {
+			return
+		}
+		for i, val := range values {
+			v := val.(*sql.RawBytes)
+			stringVals[i] = sql.NullString{String: string(*v), Valid: *v != nil}
+		}
+		// The watermark is compared with the column in the next run so it
+		// has to be the value from the database, not the one written
+		if mark != nil {
+			if err = mark.add(stringVals); err != nil {
+				return res, fmt.Errorf("row %d: %w", res.Rows+1, err)
+			}
+		}
+		if blobs != nil {
+			if err = blobs.write(stringVals); err != nil {
+				return res, outputError(fmt.Errorf("row %d: writing blob: %w", res.Rows+1, err))
+			}
+		}
+		// Rows are partitioned by the value from the database, not the one
+		// written, so masking the column doesn't put every row in one file
+		w, r := writer, &res
+		if parts != nil {
+			part, err := parts.get(stringVals)
+			if err != nil {
+				return res, fmt.Errorf("row %d: %w", res.Rows+1, err)
+			}
+			w, r = part.writer, &part.res
+		}
+		// Like the watermark, the key is where the next page starts so it's
+		// the value from the database, not the masked or replaced one
+		var key string
+		if keyIndex >= 0 {
+			key = stringVals[keyIndex].String
+		}
+		if err = applyTransforms(transforms, columns, stringVals); err != nil {
+			return res, fmt.Errorf("row %d: %w", res.Rows+1, err)
+		}
+		if err = w.WriteRow(stringVals); err != nil {
+			return res, outputError(fmt.Errorf("row %d: %w", res.Rows+1, err))
+		}
+		if keyIndex >= 0 {
+			if r.Rows == 0 {
+				r.FirstKey = &key
+			}
+			r.LastKey = &key
+		}
+		r.Rows++
+		if parts != nil {
+			res.Rows++
+		}
+	}
+	// Next returns false both at the end of the result set and when reading
+	// fails, e.g. when the connection drops partway through
+	if err = rows.Err(); err != nil {
+		return res, fmt.Errorf("reading row %d: %w", res.Rows+1, err)
+	}
+	if parts != nil {
+		err = parts.flush()
+		res.partitions = parts.results()
+	} else {
+		err = writer.Flush()
+	}
+	if err != nil {
+		return res, outputError(fmt.Errorf("flushing the output: %w", err))
+	}
+	if mark != nil {
+		res.Watermark = mark.max
+	}
+	if blobs != nil {
+		res.BlobFiles, res.BlobBytes = blobs.files, blobs.bytes
+	}
+	if opts.Sample > 0 {
+		res.Rows = min(res.Rows, opts.Sample)
+	}
+	return
+}
+
+// newOutputWriter writes the comment to output and returns the RowWriter for
+// it after writing the header
+func newOutputWriter(output io.Writer, columns []string, types []*sql.ColumnType, footer *footer, opts WriteOptions) (writer RowWriter, err error) {
+	if opts.CommentPrefix != "" {
+		if _, err = fmt.Fprintf(output, "%s%s\n", opts.CommentPrefix, opts.comment); err != nil {
+			return nil, outputError(fmt.Errorf("writing the comment: %w", err))
+		}
+	}
+	if opts.Profile {
+		if writer, err = newProfileWriter(output, types, opts); err != nil {
+			return
+		}
+	} else if opts.Transpose {
+		// The written columns are field and value, not the ones of the query
+		if writer, err = newRowWriter(output, nil, opts); err != nil {
+			return
+		}
+		writer = newTransposeWriter(writer, output, types)
+	} else if writer, err = newRowWriter(output, types, opts); err != nil {
+		return
+	}
+	if footer != nil {
+		writer = &footerWriter{RowWriter: writer, footer: footer, output: output, open: opts.openFooter}
+	}
+	if opts.Sample > 0 {
+		writer = newSampleWriter(writer, opts.Sample, opts.SampleSeed)
+	}
+	if opts.NoHeader {
+		return
+	}
+	header := columns
+	if opts.HeaderPrefix != "" || opts.HeaderSuffix != "" {
+		header = make([]string, len(columns))
+		for i, c := range columns {
+			header[i] = opts.HeaderPrefix + c + opts.HeaderSuffix
+		}
+	}
+	if err = writer.WriteHeader(header); err != nil {
+		return nil, outputError(fmt.Errorf("writing the header: %w", err))
+	}
+	if opts.TypesHeader {
+		typeNames := make([]string, len(types))
+		for i, c := range columnSchemas(types) {
+			typeNames[i] = c.TypeName()
+		}
+		if err = writer.WriteHeader(typeNames); err != nil {
+			return nil, outputError(fmt.Errorf("writing the types header: %w", err))
+		}
+	}
+	return
+}
+
+// duplicateColumns returns the names used by more than one column
+func duplicateColumns(columns []string) (dupes []string) {
+	seen := make(map[string]int, len(columns))
+	for _, c := range columns {
+		seen[c]++
+		if seen[c] == 2 {
+			dupes = append(dupes, c)
+		}
+	}
+	return
+}
+
+// truncateTableNames returns the columns with everything up to the last . of
+// their names removed
+func truncateTableNames(columns []string) []string {
+	truncated := make([]string, len(columns))
+	for i, c := range columns {
+		truncated[i] = c[strings.LastIndex(c, ".")+1:]
+	}
+	return truncated
+}
+
+// dedupeColumns renames repeated columns to name_2, name_3 and so on, skipping
+// any names that are already taken
+func dedupeColumns(columns []string) []string {
+	taken := make(map[string]bool, len(columns))
+	for _, c := range columns {
+		taken[c] = true
+	}
+	deduped := make([]string, len(columns))
+	used := make(map[string]bool, len(columns))
+	for i, c := range columns {
+		name := c
+		for n := 2; used[name] || name != c && taken[name]; n++ {
+			name = fmt.Sprintf("%s_%d", c, n)
+		}
+		used[name] = true
+		deduped[i] = name
+	}
+	return deduped
+}
+
+// indexOf returns the index of the column or -1 if it isn't in columns
+func indexOf(columns []string, column string) int {
+	if column == "" {
+		return -1
+	}
+	for i, c := range columns {
+		if c == column {
+			return i
+		}
+	}
+	return -1
+}
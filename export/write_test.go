@@ -0,0 +1,250 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var errWriteFailed = errors.New("no space left on device")
+
+// failingWriter fails every write after the first limit bytes, like a disk
+// that fills up partway through the export. Close fails with closeErr.
+type failingWriter struct {
+	bytes.Buffer
+	limit    int
+	closeErr error
+	aborted  bool
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.Len()+len(p) > w.limit {
+		n, _ := w.Buffer.Write(p[:w.limit-w.Len()])
+		return n, errWriteFailed
+	}
+	return w.Buffer.Write(p)
+}
+
+func (w *failingWriter) Close() error {
+	return w.closeErr
+}
+
+func (w *failingWriter) Abort(error) {
+	w.aborted = true
+}
+
+func TestWriteResultSetFailingWriter(t *testing.T) {
+	// The rows are almost 13 KB of CSV, more than the buffer of the writer, so
+	// the last limit is only reached when the output is flushed
+	for _, limit := range []int{0, 5, 100, 5000, 12500} {
+		t.Run(fmt.Sprint(limit), func(t *testing.T) {
+			output := &failingWriter{limit: limit}
+			open := func() (io.WriteCloser, error) { return output, nil }
+			_, err := writeResultSet(testRows(t, numberedRows(1000)), open, WriteOptions{})
+			if !errors.Is(err, errWriteFailed) {
+				t.Fatalf("got error %v, want %v", err, errWriteFailed)
+			}
+			var outputErr *OutputError
+			if !errors.As(err, &outputErr) {
+				t.Errorf("got a %T, want an *OutputError", err)
+			}
+			if !output.aborted {
+				t.Error("the output wasn't aborted")
+			}
+		})
+	}
+}
+
+func TestWriteResultSetCloseError(t *testing.T) {
+	closeErr := errors.New("stale NFS file handle")
+	output := &failingWriter{limit: 1 << 20, closeErr: closeErr}
+	open := func() (io.WriteCloser, error) { return output, nil }
+	_, err := writeResultSet(testRows(t, numberedRows(10)), open, WriteOptions{})
+	if !errors.Is(err, closeErr) {
+		t.Fatalf("got error %v, want %v", err, closeErr)
+	}
+	if !strings.Contains(err.Error(), "closing the output") {
+		t.Errorf("got error %q, want it to mention closing the output", err)
+	}
+}
+
+func TestGetOutputFailingWriter(t *testing.T) {
+	for _, compress := range []string{CompressNone, CompressGzip, CompressZstd} {
+		t.Run(compress, func(t *testing.T) {
+			var written int64
+			output := &failingWriter{limit: 100}
+			data := OutputData{Stdout: output, Compress: compress}
+			open := func() (io.WriteCloser, error) {
+				w, _, err := getOutput(data, &written)
+				return w, err
+			}
+			_, err := writeResultSet(testRows(t, numberedRows(1000)), open, WriteOptions{})
+			if !errors.Is(err, errWriteFailed) {
+				t.Fatalf("got error %v, want %v", err, errWriteFailed)
+			}
+		})
+	}
+}
+
+func TestWriteResultSetReadError(t *testing.T) {
+	// The connection drops after some of the rows have been read
+	readErr := errors.New("invalid connection")
+	for _, k := range []int{0, 1, 500} {
+		t.Run(fmt.Sprint(k), func(t *testing.T) {
+			set := numberedRows(k)
+			set.err = readErr
+			filename := filepath.Join(t.TempDir(), "export.csv")
+			var written int64
+			open := func() (io.WriteCloser, error) {
+				w, _, err := getOutput(OutputData{OutputTemplate: filename}, &written)
+				return w, err
+			}
+			res, err := writeResultSet(testRows(t, set), open, WriteOptions{})
+			if !errors.Is(err, readErr) {
+				t.Fatalf("got error %v, want %v", err, readErr)
+			}
+			if want := fmt.Sprintf("reading row %d: ", k+1); !strings.HasPrefix(err.Error(), want) {
+				t.Errorf("got error %q, want it to start with %q", err, want)
+			}
+			if res.Rows != k {
+				t.Errorf("got %d rows, want %d", res.Rows, k)
+			}
+			if _, err := os.Stat(filename); !os.IsNotExist(err) {
+				t.Errorf("the partial output wasn't removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestExportReadErrorInLaterResultSet(t *testing.T) {
+	readErr := errors.New("invalid connection")
+	failing := numberedRows(10)
+	failing.err = readErr
+	dir := t.TempDir()
+	e := &Exporter{
+		DB:     testDB(t, map[string][]testResultSet{"CALL report()": {numberedRows(10), failing}}),
+		Output: OutputData{OutputTemplate: filepath.Join(dir, "report-%d.csv")},
+	}
+	err := e.Export(context.Background(), Query{SQL: "CALL report()"})
+	if !errors.Is(err, readErr) {
+		t.Fatalf("got error %v, want %v", err, readErr)
+	}
+	if !strings.Contains(err.Error(), "reading row 11: ") {
+		t.Errorf("got error %q, want it to mention reading row 11", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "report-0.csv")); err != nil {
+		t.Errorf("the first result set wasn't kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "report-1.csv")); !os.IsNotExist(err) {
+		t.Errorf("the partial output of the second result set wasn't removed: %v", err)
+	}
+}
+
+func TestHeaderPrefixNamesKeys(t *testing.T) {
+	tests := []struct {
+		format, template, want string
+	}{
+		{FormatJSON, "", "[\n{\"src_id_x\":\"1\",\"src_name_x\":\"name 1\"}\n]\n"},
+		{FormatNDJSON, "", "{\"src_id_x\":\"1\",\"src_name_x\":\"name 1\"}\n"},
+		{FormatTemplate, "{{.src_id_x}}={{.src_name_x}}", "1=name 1\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var b bytes.Buffer
+			open := func() (io.WriteCloser, error) { return NopCloser{&b}, nil }
+			opts := WriteOptions{Format: tt.format, Template: tt.template, HeaderPrefix: "src_", HeaderSuffix: "_x"}
+			if _, err := writeResultSet(testRows(t, numberedRows(1)), open, opts); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != tt.want {
+				t.Errorf("got %q, want %q", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestTableNamesKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		columns  []string
+		truncate bool
+		format   string
+		template string
+		want     string
+	}{
+		{"truncated json", []string{"user.id", "order.total"}, true, FormatJSON, "", "[\n{\"id\":\"1\",\"total\":\"9.50\"}\n]\n"},
+		{"truncated ndjson", []string{"user.id", "order.total"}, true, FormatNDJSON, "", "{\"id\":\"1\",\"total\":\"9.50\"}\n"},
+		{"truncated template", []string{"user.id", "order.total"}, true, FormatTemplate, "{{.id}} {{.total}}", "1 9.50\n"},
+		// The driver names the columns like this with --qualify-columns
+		{"qualified json", []string{"u.id", "o.id"}, false, FormatJSON, "", "[\n{\"u.id\":\"1\",\"o.id\":\"9.50\"}\n]\n"},
+		{"qualified ndjson", []string{"u.id", "o.id"}, false, FormatNDJSON, "", "{\"u.id\":\"1\",\"o.id\":\"9.50\"}\n"},
+		{"qualified template", []string{"u.id", "o.id"}, false, FormatTemplate, `{{index . "u.id"}} {{index . "o.id"}}`, "1 9.50\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := testResultSet{columns: tt.columns, rows: [][]string{{"1", "9.50"}}}
+			var b bytes.Buffer
+			open := func() (io.WriteCloser, error) { return NopCloser{&b}, nil }
+			opts := WriteOptions{Format: tt.format, Template: tt.template, TruncateTableNames: tt.truncate}
+			if _, err := writeResultSet(testRows(t, set), open, opts); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != tt.want {
+				t.Errorf("got %q, want %q", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteOptionsValidate(t *testing.T) {
+	tests := []struct {
+		opts WriteOptions
+		want string
+	}{
+		{WriteOptions{}, ""},
+		{WriteOptions{Format: FormatJSON, Typed: true, JSONOmitNull: true}, ""},
+		{WriteOptions{Quote: QuoteNone, EscapeChar: `\`, QuoteChar: "'"}, ""},
+		{WriteOptions{Format: FormatTemplate, Template: "{{.id}}"}, ""},
+		{WriteOptions{Format: "xml"}, `Invalid format "xml"`},
+		{WriteOptions{HeaderOnly: true, NoHeader: true}, "--header-only can't be used with --no-header"},
+		{WriteOptions{Format: FormatNDJSON, HeaderOnly: true}, "--header-only can only be used with the csv, tsv and table formats"},
+		{WriteOptions{Format: FormatTemplate}, "--format template needs a --template"},
+		{WriteOptions{Format: FormatTemplate, Template: "{{.id"}, "unclosed action"},
+		{WriteOptions{Template: "{{.id}}"}, "--template can only be used with --format template"},
+		{WriteOptions{Format: FormatVertical, NoHeader: true}, "--no-header can't be used with --format vertical"},
+		{WriteOptions{Format: FormatJSON, CommentPrefix: "#"}, "--comment can only be used with"},
+		{WriteOptions{Format: FormatTable, TypesHeader: true}, "--types-header can only be used with"},
+		{WriteOptions{Format: FormatJSON, Transpose: true}, "--transpose can only be used with the csv and tsv formats"},
+		{WriteOptions{Transpose: true, QuoteColumns: []string{"id"}}, "--transpose can't be used with"},
+		{WriteOptions{Format: FormatVertical, Profile: true}, "--profile-columns can only be used with"},
+		{WriteOptions{Profile: true, Sample: 10}, "--profile-columns can't be used with"},
+		{WriteOptions{JSONOmitNull: true}, "--json-omit-null can only be used with"},
+		{WriteOptions{Quote: "some"}, `Invalid quoting "some"`},
+		{WriteOptions{Quote: QuoteNone, QuoteEmpty: true}, "--quote-empty can't be used with --quote none"},
+		{WriteOptions{EscapeChar: `\`}, "--escape-char can only be used with --quote none"},
+		{WriteOptions{Quote: QuoteNone, EscapeChar: ","}, "Invalid escape character"},
+		{WriteOptions{QuoteChar: "''"}, "Invalid quote character"},
+		{WriteOptions{GeometryFormat: "wkb"}, `Invalid geometry format "wkb"`},
+		{WriteOptions{Sample: -1}, "--sample can't be negative"},
+		{WriteOptions{BlobDir: "blobs"}, "--blob-dir and --blob-column must be used together"},
+		{WriteOptions{BlobKey: "id"}, "--blob-key can only be used with --blob-dir"},
+		{WriteOptions{BlobDir: "blobs", BlobColumns: []string{"data"}, Sample: 5}, "--blob-dir can't be used with --sample"},
+		{WriteOptions{PartitionBy: "region", HeaderOnly: true}, "--partition-by can't be used with"},
+	}
+	for _, tt := range tests {
+		err := tt.opts.Validate()
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%+v: %v", tt.opts, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%+v: got %v, want an error containing %q", tt.opts, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// newConnector returns the connector for cfg, or a failoverConnector when
+// there's more than one address to try
+func newConnector(cfg *mysql.Config, addrs []string) (driver.Connector, error) {
+	if len(addrs) == 1 {
+		return mysql.NewConnector(cfg)
+	}
+	c := &failoverConnector{addrs: addrs, last: -1}
+	for _, addr := range addrs {
+		hostCfg := cfg.Clone()
+		hostCfg.Addr = addr
+		connector, err := mysql.NewConnector(hostCfg)
+		if err != nil {
+			return nil, err
+		}
+		c.connectors = append(c.connectors, connector)
+	}
+	return c, nil
+}
+
+// failoverConnector connects to the first of several hosts that accepts the
+// connection, such as a pool of read replicas. Once a host works the next
+// connections try it first so the queries of one export normally see the
+// same server.
+type failoverConnector struct {
+	addrs      []string
+	connectors []driver.Connector
+	mu         sync.Mutex
+	// current is the host tried first, last the one connected to most
+	// recently, which is logged when it changes
+	current, last int
+}
+
+func (c *failoverConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.mu.Lock()
+	start := c.current
+	c.mu.Unlock()
+	// Every error is wrapped so callers can still tell a network failure
+	// from a rejected login
+	var format []string
+	var errs []any
+	for i := range c.connectors {
+		host := (start + i) % len(c.connectors)
+		conn, err := c.connectors[host].Connect(ctx)
+		if err == nil {
+			c.connected(host)
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		slog.Debug(fmt.Sprintf("couldn't connect to %s: %s", c.addrs[host], err), "host", c.addrs[host], "error", err)
+		format = append(format, "%s: %w")
+		errs = append(errs, c.addrs[host], err)
+	}
+	return nil, fmt.Errorf("none of the hosts accepted the connection, "+strings.Join(format, "; "), errs...)
+}
+
+func (c *failoverConnector) connected(host int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current = host
+	if c.last != host {
+		c.last = host
+		slog.Debug(fmt.Sprintf("connected to %s", c.addrs[host]), "host", c.addrs[host])
+	}
+}
+
+func (c *failoverConnector) Driver() driver.Driver {
+	return c.connectors[0].Driver()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+const (
+	LogText = "text"
+	LogJSON = "json"
+)
+
+// setupLogging sets the default logger that messages are written to stderr
+// with. The text format writes just the message so the output reads the same
+// as it always has, while the json format writes every attribute for log
+// collectors. Progress is logged at the debug level so it only shows up in
+// the json format, or in the text format with verbose. quiet leaves only the
+// errors in both formats.
+func setupLogging(w io.Writer, format string, verbose, quiet bool) error {
+	switch format {
+	case "", LogText:
+		level := slog.LevelInfo
+		if verbose {
+			level = slog.LevelDebug
+		}
+		if quiet {
+			level = slog.LevelError
+		}
+		slog.SetDefault(slog.New(&textHandler{w: w, level: level}))
+	case LogJSON:
+		level := slog.LevelDebug
+		if quiet {
+			level = slog.LevelError
+		}
+		slog.SetDefault(slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})))
+	default:
+		return fmt.Errorf("Invalid log format %q, expected text or json", format)
+	}
+	return nil
+}
+
+// textHandler writes the message of each record on its own line, prefixed
+// with the level for warnings and errors. Attributes are left out since the
+// messages already include the values that matter.
+type textHandler struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level slog.Level
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	prefix := ""
+	switch {
+	case r.Level >= slog.LevelError:
+		prefix = "error: "
+	case r.Level >= slog.LevelWarn:
+		prefix = "warning: "
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := fmt.Fprintf(h.w, "%s%s\n", prefix, r.Message)
+	return err
+}
+
+func (h *textHandler) WithAttrs([]slog.Attr) slog.Handler {
+	return h
+}
+
+func (h *textHandler) WithGroup(string) slog.Handler {
+	return h
+}
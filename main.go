@@ -1,24 +1,35 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"encoding/csv"
+	"database/sql/driver"
 	"fmt"
 	"io"
+	"log/slog"
+	"math/rand/v2"
 	"os"
-	"regexp"
+	"os/signal"
+	"path"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	_ "embed"
 
-	_ "github.com/go-sql-driver/mysql"
-
+	"github.com/go-sql-driver/mysql"
 	"github.com/urfave/cli/v2"
+	"github.com/wyattis/mysql2csv/export"
 )
 
 //go:embed VERSION
 var version string
 
+// jsonErrors is set by --json-errors since the error is printed after the app
+// has finished running
+var jsonErrors bool
+
 var app = cli.App{
 	Name:                 "mysql2csv",
 	Usage:                "Execute a query against a MySQL database and output the results as CSV",
@@ -26,12 +37,20 @@ var app = cli.App{
 	Version:              version,
 	EnableBashCompletion: true,
 	Args:                 true,
-	ArgsUsage:            "<database>",
-	Flags: []cli.Flag{
+	ArgsUsage:            "<database> [query]",
+	// Queries and headers can contain commas so repeated flags are never split
+	DisableSliceFlagSeparator: true,
+	Flags: configurableFlags([]cli.Flag{
 		&cli.StringFlag{
-			Name:    "execute",
-			Aliases: []string{"e"},
-			Usage:   "The query to execute. If not provided, the query will be read from stdin",
+			Name:    "config",
+			Usage:   "Read the default value of any flag from a .yaml, .toml or .json file, e.g. format: tsv. Flags and environment variables take precedence over the file",
+			EnvVars: []string{"MYSQL2CSV_CONFIG"},
+		},
+		&cli.StringSliceFlag{
+			Name:      "execute",
+			Aliases:   []string{"e"},
+			KeepSpace: true,
+			Usage:     "The query to execute. Can be repeated to run several queries in order. If not provided, the query will be read from stdin",
 		},
 		&cli.StringFlag{
 			Name:    "user",
@@ -50,7 +69,7 @@ var app = cli.App{
 			Name:    "host",
 			Aliases: []string{"h"},
 			EnvVars: []string{"MYSQL_HOST"},
-			Usage:   "MySQL host",
+			Usage:   "MySQL host. Give a comma-separated list, e.g. replica-1,replica-2:3307, to try each one in order until one accepts the connection",
 			Value:   "127.0.0.1",
 		},
 		&cli.IntFlag{
@@ -68,36 +87,568 @@ var app = cli.App{
 			Name:  "no-header",
 			Usage: "Do not output the column names as the first row",
 		},
+		&cli.StringFlag{
+			Name:    "format",
+			Aliases: []string{"f"},
+			Usage: formatUsageString(`The output format. One of csv, tsv, mysql, table, vertical, json, ndjson or template. tsv escapes tabs, line breaks and backslashes like mysql --batch instead of quoting.
+			mysql is the same with NULL written as \N, the default format of SELECT ... INTO OUTFILE and LOAD DATA INFILE.
+			The table format aligns the columns for reading in a terminal and vertical writes each row as a block of column: value lines like mysql's \G.
+			The json format writes an array of objects for each result set and ndjson writes one object per line.
+			The template format renders each row with --template.
+			When it isn't set the format is detected from the --output extension: .csv, .tsv, .tab, .txt (table), .json, .ndjson and .jsonl`),
+			Value: export.FormatCSV,
+		},
+		&cli.StringFlag{
+			Name:  "template",
+			Usage: "The Go text/template each row is rendered with by --format template, e.g. '{{.id}},{{.name | upper}}'. Columns are available by name and upper, lower and default can be used along with the builtins like printf",
+		},
+		&cli.StringFlag{
+			Name:  "template-file",
+			Usage: "Read --template from a file",
+		},
+		&cli.BoolFlag{
+			Name:    "transpose",
+			Aliases: []string{"pivot"},
+			Usage:   "Write each row as field,value pairs, one line per column, with a blank line between rows. Useful for inspecting a single wide record",
+		},
+		&cli.BoolFlag{
+			Name:  "profile-columns",
+			Usage: "Instead of the rows, write a report with a row per column: its type, the number of rows, non-NULL values and distinct values (estimated), the percentage of NULLs, the smallest and largest value and the average length",
+		},
+		&cli.BoolFlag{
+			Name:  "dedupe-headers",
+			Usage: "Rename repeated column names, such as the id of each joined table, to id, id_2, id_3, etc. Otherwise a warning is printed",
+		},
+		&cli.StringSliceFlag{
+			Name:  "add-column",
+			Usage: "Add a column with the same value in every row to each result set, e.g. --add-column export_date=2024-05-01 --add-column source=prod. Can be repeated. The export fails if the query already returns a column with the name",
+		},
+		&cli.StringFlag{
+			Name:  "add-column-position",
+			Usage: "Where the --add-column columns go, append to put them after the columns of the query or prepend to put them in front",
+			Value: "append",
+		},
+		&cli.BoolFlag{
+			Name:  "qualify-columns",
+			Usage: "Name the columns that come from a table table.column, using the alias the query gives the table, so the columns of joined tables can be told apart. Computed columns keep their name",
+		},
+		&cli.BoolFlag{
+			Name:  "truncate-table-names",
+			Usage: "Remove everything up to the last . from each column name, such as the table of an alias like AS `user.id`",
+		},
+		&cli.BoolFlag{
+			Name:  "types-header",
+			Usage: "Write a second header row with the MySQL type of each column, e.g. INT, VARCHAR(255) or DATETIME. Left out along with the header by --no-header",
+		},
+		&cli.StringFlag{
+			Name:  "header-prefix",
+			Usage: "Add this to the start of every column name in the header, e.g. src_. Options like --mask still use the names from the query",
+		},
+		&cli.StringFlag{
+			Name:  "header-suffix",
+			Usage: "Add this to the end of every column name in the header",
+		},
+		&cli.BoolFlag{
+			Name:  "header-only",
+			Usage: "Only write the column names of each result set. SELECT statements are run with LIMIT 0 so no rows are fetched",
+		},
+		&cli.StringFlag{
+			Name:  "quote-char",
+			Usage: "The character used to quote CSV fields. Quotes inside of a field are escaped by doubling them",
+			Value: `"`,
+		},
+		&cli.StringFlag{
+			Name:  "quote",
+			Usage: "How CSV fields are quoted. minimal only quotes the fields that need it, all quotes every field and none never quotes and fails on a value that needs quotes",
+			Value: export.QuoteMinimal,
+		},
+		&cli.StringFlag{
+			Name:  "escape-char",
+			Usage: "With --quote none, escape commas, line breaks, NUL and the escape character itself inside of values with this character and write NULL as \\N, like FIELDS ESCAPED BY '\\' of LOAD DATA",
+		},
+		&cli.StringSliceFlag{
+			Name:  "quote-columns",
+			Usage: "Always quote the values of these columns whatever the --quote mode, e.g. --quote none --quote-columns name,notes. Can be repeated",
+		},
+		&cli.BoolFlag{
+			Name:  "quote-empty",
+			Usage: "Write empty strings as \"\" in CSV so they can be told apart from NULL, which is written as an empty field",
+		},
+		&cli.BoolFlag{
+			Name:    "comment",
+			Aliases: []string{"comment-header"},
+			Usage:   "Start each output with a comment line recording the query, when it was exported, the database and server it came from and the mysql2csv version. CSV has no comments so strict parsers may read it as a row",
+		},
+		&cli.StringFlag{
+			Name:  "comment-prefix",
+			Usage: "The text the --comment line starts with",
+			Value: "# ",
+		},
+		&cli.BoolFlag{
+			Name:  "pretty",
+			Usage: "Shorthand for --format table",
+		},
+		&cli.BoolFlag{
+			Name:  "typed",
+			Usage: "Write numbers as JSON numbers and JSON columns as JSON instead of strings when using --format json or ndjson",
+		},
+		&cli.BoolFlag{
+			Name:  "decimal-as-number",
+			Usage: "Write DECIMAL columns as numbers with --typed. They are strings by default since many JSON parsers would lose precision",
+		},
+		&cli.StringSliceFlag{
+			Name:  "bool-column",
+			Usage: "Columns to write as true/false with --typed. Needed because the driver can't tell TINYINT(1) apart from other TINYINTs",
+		},
+		&cli.BoolFlag{
+			Name:  "json-omit-null",
+			Usage: "Leave the keys of NULL values out of json and ndjson objects instead of writing \"column\": null",
+		},
+		&cli.StringFlag{
+			Name:  "replace-newlines",
+			Usage: "Replace the line breaks (\\r\\n, \\r and \\n) inside of values with this string, such as \" \", so each row is on a single line. Column names are not changed",
+		},
+		&cli.BoolFlag{
+			Name:  "safe-excel",
+			Usage: "Prefix string values that start with =, +, - or @ so Excel and Google Sheets don't run them as formulas. Numeric columns aren't changed",
+		},
+		&cli.StringFlag{
+			Name:  "safe-excel-prefix",
+			Usage: "What --safe-excel puts in front of a value, quote (') or tab",
+			Value: export.SafeExcelQuote,
+		},
+		&cli.BoolFlag{
+			Name:  "trim-space",
+			Usage: "Strip leading and trailing whitespace from every value, e.g. the padding of CHAR columns. Headers are left as is",
+		},
+		&cli.StringFlag{
+			Name:  "geometry-format",
+			Usage: "Convert spatial columns from MySQL's binary format to wkt or geojson",
+		},
+		&cli.StringSliceFlag{
+			Name: "mask",
+			Usage: formatUsageString(`Redact a column before it is written. Can be repeated. Use column=mode to choose how:
+			- full: replace the value with *** (the default)
+			- lastN: keep the last N characters, e.g. ssn=last4
+			- hash: replace the value with its SHA-256 hash`),
+		},
+		&cli.StringFlag{
+			Name:    "mask-salt",
+			Usage:   "Salt prepended to values before they are hashed by --mask column=hash. Use the same salt to get the same hashes across exports",
+			EnvVars: []string{"MYSQL2CSV_MASK_SALT"},
+		},
+		&cli.StringSliceFlag{
+			Name:  "null-if",
+			Usage: "Write values that are exactly this string as NULL, e.g. --null-if -1, or only in one column with --null-if 'updated_at=0000-00-00 00:00:00'. Can be repeated",
+		},
+		&cli.StringSliceFlag{
+			Name:  "replace",
+			Usage: "Replace every occurrence of old with new in the exported values, e.g. --replace 'N/A='. Can be repeated and the replacements are applied in order",
+		},
+		&cli.StringSliceFlag{
+			Name:  "replace-regex",
+			Usage: "Like --replace but old is a regular expression and new can refer to its groups with $1, e.g. --replace-regex '([^@]+)@example.com=$1@example.net'. Applied after every --replace",
+		},
+		&cli.StringFlag{
+			Name:  "blob-dir",
+			Usage: "Write each non-NULL value of the --blob-column columns to its own file under this directory and put the path of the file in the cell instead",
+		},
+		&cli.StringSliceFlag{
+			Name:  "blob-column",
+			Usage: "The columns --blob-dir writes to files, e.g. --blob-column attachment,thumbnail. Can be repeated",
+		},
+		&cli.StringFlag{
+			Name:  "blob-key",
+			Usage: "Name the --blob-dir files after the value of this column, such as the primary key, instead of the SHA-256 of their content",
+		},
+		&cli.StringFlag{
+			Name:  "expect-rows",
+			Usage: "Fail and remove the output files unless the export wrote this many rows, given as a number or as a query that counts them, e.g. --expect-rows \"SELECT COUNT(*) FROM orders WHERE created_at < CURDATE()\". The query runs in the same snapshot with --single-transaction",
+		},
+		&cli.IntFlag{
+			Name:  "max-rows-total",
+			Usage: "Stop the whole export once this many rows have been written across every query and result set. The current file is finished and the rest is skipped",
+		},
+		&cli.IntFlag{
+			Name:  "sample",
+			Usage: "Write a uniform random sample of N rows from each result set instead of every row. Only the sample is kept in memory and the rows are not in their original order",
+		},
+		&cli.IntFlag{
+			Name:  "seed",
+			Usage: "Seed the --sample random number generator so the same data gives the same sample. Random by default",
+		},
+		&cli.StringFlag{
+			Name:  "footer",
+			Usage: "A line to write after the last row of each file, e.g. --footer 'TOTAL,{rows}'. {rows} is the number of rows in the file and {sum:column} the exact total of a column",
+		},
+		&cli.BoolFlag{
+			Name:  "footer-file",
+			Usage: "Write the --footer to a .ctl file next to each output file instead of after its last row",
+		},
+		&cli.BoolFlag{
+			Name:    "skip-empty",
+			Aliases: []string{"skip-empty-result-sets"},
+			Usage:   "Don't create a file or write a header for result sets without any rows. Their file numbers are skipped so later result sets keep the same numbers",
+		},
 		&cli.StringFlag{
 			Name:    "output",
 			Aliases: []string{"o"},
 			Usage: formatUsageString(`The file to write the output to. If not provided, the output will be written to stdout. 
 			Add %d to create multiple files with a number in the filename. 
-			%0Nd will prefix the number with zeros to create a string of length N. For example, -o output-%03d.csv will create files output-001.csv, output-002.csv, etc.`),
+			%0Nd will prefix the number with zeros to create a string of length N. For example, -o output-%03d.csv will create files output-001.csv, output-002.csv, etc.
+			The placeholders {setnum} (the same number as %d), {table} (when exporting tables), {database}, {host} (the first --host without the port), {date} (YYYYMMDD), {time} (HHMMSS)
+			and {query_hash} (the first 8 characters of the query's SHA-256) can also be used.
+			%s is replaced with the value of the --partition-by column.
+			Paths starting with s3:// are uploaded directly to S3 using the standard AWS credential chain.
+			URLs starting with http:// or https:// receive each file as the body of a POST request.`),
+		},
+		&cli.StringFlag{
+			Name:  "on-complete",
+			Usage: "A shell command to run after each output file is finished, e.g. 'aws s3 cp {file} s3://bucket/'. {file}, {rows} and {index} are replaced with the file (quoted for the shell), its number of rows and its result set number. A failing command fails the export",
+		},
+		&cli.BoolFlag{
+			Name:  "on-complete-ignore-errors",
+			Usage: "Only warn when the --on-complete command fails instead of failing the export",
+		},
+		&cli.StringFlag{
+			Name:  "on-duplicate-file",
+			Usage: "What to do when an output file already exists. overwrite replaces it, error fails the export and rename writes to the next free name, export(1).csv, export(2).csv and so on",
+			Value: export.FileOverwrite,
+		},
+		&cli.StringFlag{
+			Name:  "partition-by",
+			Usage: "Write the rows to a separate file for each value of this column, e.g. -o \"region-%s.csv\" --partition-by region. Every file has its own header and stays open until the result set has been read",
+		},
+		&cli.BoolFlag{
+			Name:  "tee",
+			Usage: "Also write the output to stdout while it's written to the --output files, e.g. to watch it with head or pv. Logs and stats stay on stderr",
+		},
+		&cli.StringFlag{
+			Name:    "aws-region",
+			EnvVars: []string{"AWS_REGION"},
+			Usage:   "The AWS region to use for s3:// outputs. If not provided, the region of the bucket is looked up",
+		},
+		&cli.StringFlag{
+			Name:    "write-buffer",
+			Aliases: []string{"output-buffer-size"},
+			Usage:   "The size of the buffer used for writes to local files, such as 64KiB or 4MiB. Larger buffers mean fewer writes, which helps a lot on network filesystems. 0 turns buffering off",
+			Value:   "1MiB",
+		},
+		&cli.StringFlag{
+			Name:    "encoding",
+			Aliases: []string{"output-encoding"},
+			Usage:   "Convert the output from UTF-8 to another character set such as latin1, windows-1252 or shift_jis. This doesn't change the charset of the connection",
+		},
+		&cli.StringFlag{
+			Name:  "encoding-errors",
+			Usage: "What to do with characters that --encoding can't represent. replace writes the encoding's substitute character (0x1A for single byte character sets) and error fails the export",
+			Value: export.EncodingErrorsReplace,
+		},
+		&cli.StringFlag{
+			Name: "compress",
+			Usage: formatUsageString(`Compress the output with none, gzip or zstd. Applies to files and stdout.
+			If not provided, output files ending in .gz or .zst are compressed with gzip or zstd respectively.`),
+		},
+		&cli.BoolFlag{
+			Name:  "checksum",
+			Usage: "Write the SHA-256 of each output file to a .sha256 file next to it in sha256sum format. The checksum is of the bytes written, after compression",
+		},
+		&cli.StringSliceFlag{
+			Name:  "http-header",
+			Usage: "A header to send with http(s):// outputs in the form \"Name: value\". Can be repeated",
+		},
+		&cli.StringFlag{
+			Name:  "manifest",
+			Usage: "Write a JSON list of every file that was created with its row count, size and query to this file once the export succeeds",
+		},
+		&cli.StringFlag{
+			Name:  "manifest-key",
+			Usage: "A column to record the first and last values of for each file in the manifest",
+		},
+		&cli.StringFlag{
+			Name:  "watermark-file",
+			Usage: "Export only the rows added since the last run. The largest value of --watermark-column is saved to this file and the next run only exports the rows where the column is greater. The whole query is exported when the file doesn't exist yet",
+		},
+		&cli.StringFlag{
+			Name:  "watermark-column",
+			Usage: "The column, such as an auto increment id or an updated_at timestamp, that --watermark-file tracks",
+		},
+		&cli.StringFlag{
+			Name:  "schema-file",
+			Usage: "Write the column names and MySQL types of every result set to this file as JSON, including empty result sets",
+		},
+		&cli.BoolFlag{
+			Name:  "column-types",
+			Usage: "Write the name, MySQL type, nullability and length of each column to a .types.json file next to each output file, or to stderr when writing to stdout",
+		},
+		&cli.BoolFlag{
+			Name:  "metadata",
+			Usage: "Write a .meta.json file next to each output file, after it's finished, with the query and its parameters, when it started and finished, the rows and bytes written, the columns and their types and the mysql2csv version",
+		},
+		&cli.StringSliceFlag{
+			Name:    "table",
+			Aliases: []string{"t"},
+			Usage:   "Export every row of the table instead of running a query. Can be repeated to export several tables, one result set each",
+		},
+		&cli.BoolFlag{
+			Name:  "all-tables",
+			Usage: "Export every table in the database, one result set each",
+		},
+		&cli.StringSliceFlag{
+			Name:  "exclude-tables",
+			Usage: "Skip tables matching these glob patterns (e.g. tmp_*) when using --all-tables",
+		},
+		&cli.BoolFlag{
+			Name:  "strict-columns",
+			Usage: "Fail if a result set doesn't have the same column names, in the same order, as the one before it, even when each is written to its own file. Catches schema drift between the queries of a script, tables or --databases",
+		},
+		&cli.BoolFlag{
+			Name:  "skip-views",
+			Usage: "Skip views when using --all-tables",
 		},
+		&cli.StringFlag{
+			Name:  "databases",
+			Usage: "Run the query, or export the --table, in each database of this comma-separated list in turn over the same connections, e.g. one schema per tenant. --output needs {database} so each database gets its own file",
+		},
+		&cli.StringFlag{
+			Name:  "databases-file",
+			Usage: "Read the --databases from this file, one per line. Blank lines and lines starting with # are skipped",
+		},
+		&cli.StringFlag{
+			Name:  "hosts",
+			Usage: "Run the query on each of these servers in turn instead of on --host, a comma-separated list of [user@]host[:port] that use --user and --port unless given. Each host is written to the files of its {host}, or to one output with --merge-hosts",
+		},
+		&cli.BoolFlag{
+			Name:  "merge-hosts",
+			Usage: "Write the rows of every --hosts server to the same output, with a source_host column in front naming the host each row came from",
+		},
+		&cli.BoolFlag{
+			Name:  "fail-fast",
+			Usage: "Stop at the first of the --hosts that fails instead of exporting the others and listing the failures at the end",
+		},
+		&cli.StringFlag{
+			Name:  "where",
+			Usage: "A WHERE clause to apply to every table exported with --table or --all-tables",
+		},
+		&cli.IntFlag{
+			Name:    "jobs",
+			Aliases: []string{"j", "parallel"},
+			Usage: formatUsageString(`The number of tables or statements to export at the same time. Each one uses its own connection and must be written to its own file.
+			A script is split into its individual statements so they can be run in parallel.`),
+			Value: 1,
+		},
+		&cli.BoolFlag{
+			Name:  "allow-multi-statements",
+			Usage: "Allow a query to contain more than one statement, such as a script read from stdin. Without it a query with a second statement is rejected before anything runs",
+		},
+		&cli.BoolFlag{
+			Name:  "single-transaction",
+			Usage: "Run every query and table on one connection inside START TRANSACTION WITH CONSISTENT SNAPSHOT so they all see the data as it was when the export started. Can't be used with --jobs",
+		},
+		&cli.StringSliceFlag{
+			Name:  "init-command",
+			Usage: "A statement to run on the connection before the query, e.g. --init-command \"SET time_zone = '+00:00'\". Can be repeated and they run in order",
+		},
+		&cli.BoolFlag{
+			Name:  "read-only",
+			Usage: "Set each connection to SET SESSION TRANSACTION READ ONLY before running the query so statements that change data fail. A warning is printed for statements that don't look like they only read",
+		},
+		&cli.BoolFlag{
+			Name:  "prepared",
+			Usage: "Run each query as a server-side prepared statement instead of as text. Scripts are split into their statements since each one is prepared on its own",
+		},
+		&cli.BoolFlag{
+			Name:  "show-warnings",
+			Usage: "Write the warnings MySQL reports for each query to stderr, such as truncated or converted values. Only the warnings of the last statement of a multi-statement query are reported",
+		},
+		&cli.BoolFlag{
+			Name:  "strict",
+			Usage: "Fail with a non-zero exit code when a query produces any warnings. Implies --show-warnings",
+		},
+		&cli.BoolFlag{
+			Name:    "keep-going",
+			Aliases: []string{"ignore-errors"},
+			Usage:   "Keep running the rest of the statements when one fails. The failures are listed at the end and the exit code is still non-zero",
+		},
+		&cli.StringFlag{
+			Name: "paginate-column",
+			Usage: formatUsageString(`Export a single SELECT in pages using keyset pagination on this column, which must be unique and not null.
+			Each page is written to its own file and is fetched with WHERE column > (the last value of the previous page) instead of OFFSET`),
+		},
+		&cli.IntFlag{
+			Name:  "page-size",
+			Usage: "The number of rows in each page when using --paginate-column",
+			Value: 100000,
+		},
+		&cli.BoolFlag{
+			Name:  "connect-only",
+			Usage: "Only verify that a connection can be made to the database and exit. No query is executed",
+		},
+		&cli.StringFlag{
+			Name:  "log-format",
+			Usage: "The format of the messages written to stderr. One of text or json. json writes one object per line with fields like level, msg, file, rows and duration_ms",
+			Value: LogText,
+		},
+		&cli.BoolFlag{
+			Name:  "verbose",
+			Usage: "Also write the debug messages to stderr with the text --log-format, like the host that was connected to and each result set written. The json format always includes them",
+		},
+		&cli.BoolFlag{
+			Name:    "quiet",
+			Aliases: []string{"q"},
+			Usage:   "Only write the error that stops the export to stderr, without the warnings, progress and --stats. The output of --on-complete commands isn't affected",
+		},
+		&cli.BoolFlag{
+			Name:  "stats",
+			Usage: "When the export finishes, write the number of rows, files and bytes written, the elapsed time and the average rows per second to stderr",
+		},
+		&cli.BoolFlag{
+			Name:  "json-errors",
+			Usage: "If the export fails, write a JSON object to stderr with the error, the stage that failed (connect, query, write or flags), the query and the masked DSN",
+		},
+		&cli.DurationFlag{
+			Name:  "connect-timeout",
+			Usage: "How long to wait for the database to respond when using --connect-only",
+			Value: 10 * time.Second,
+		},
+		&cli.BoolFlag{
+			Name:  "allow-native-passwords",
+			Usage: "Allow the mysql_native_password authentication plugin. Use --allow-native-passwords=false to refuse it",
+			Value: true,
+		},
+		&cli.BoolFlag{
+			Name:  "allow-old-passwords",
+			Usage: "Allow the insecure pre-4.1 password hashing used by some old MySQL and MariaDB servers",
+		},
+		&cli.BoolFlag{
+			Name:  "allow-cleartext-passwords",
+			Usage: "Allow sending the password in cleartext, as needed by the PAM and LDAP authentication plugins. Only use this over TLS",
+		},
+		&cli.StringFlag{
+			Name:  "time-zone",
+			Usage: "Set the time zone of the session, e.g. UTC, +02:00 or Europe/Paris, so TIMESTAMP columns are exported in it. DATETIME columns are never converted. Named zones other than UTC need the server's time zone tables",
+		},
+		&cli.BoolFlag{
+			Name:  "ssl-verify-skip",
+			Usage: "Connect with TLS without verifying the server's certificate. This is insecure and only meant for development servers with self-signed certificates",
+		},
+		&cli.IntFlag{
+			Name:  "max-open-conns",
+			Usage: "The most connections open to the database at once. 0 means no limit, or the number of --jobs when running in parallel",
+		},
+		&cli.IntFlag{
+			Name:  "max-idle-conns",
+			Usage: "The most idle connections kept open for reuse. 0 or less keeps none",
+			Value: 2,
+		},
+		&cli.DurationFlag{
+			Name:  "conn-max-lifetime",
+			Usage: "Close connections once they've been open this long, e.g. 5m. 0 keeps them open",
+		},
+		&cli.IntFlag{
+			Name:    "max-rows-per-second",
+			Aliases: []string{"throttle"},
+			Usage:   "Read at most this many rows per second, across every job, so a large export doesn't overload the server. Short bursts of up to a second's worth of rows aren't slowed down. 0 is unlimited",
+		},
+		&cli.IntFlag{
+			Name:  "query-retries",
+			Usage: "Run a query again up to N times when it fails with a deadlock, lock wait timeout or lost connection. Files it already wrote are written again from the start",
+		},
+		&cli.DurationFlag{
+			Name:  "query-retry-delay",
+			Usage: "How long to wait before the first --query-retries retry. The wait doubles after each retry",
+			Value: time.Second,
+		},
+	}),
+	Before: func(c *cli.Context) error {
+		if err := loadConfigFile(c); err != nil {
+			return usageError(err)
+		}
+		jsonErrors = c.Bool("json-errors")
+		if c.Bool("quiet") && c.Bool("verbose") {
+			return usageError(fmt.Errorf("--quiet can't be used with --verbose"))
+		}
+		if err := setupLogging(c.App.ErrWriter, c.String("log-format"), c.Bool("verbose"), c.Bool("quiet")); err != nil {
+			return usageError(err)
+		}
+		return nil
+	},
+	OnUsageError: func(c *cli.Context, err error, isSubcommand bool) error {
+		return usageError(err)
 	},
 	Action: func(c *cli.Context) (err error) {
-		query := c.String("execute")
-
-		// Try reading the query from stdin if it wasn't provided as an argument
-		if strings.TrimSpace(query) == "" {
-			stat, err := os.Stdin.Stat()
-			if err != nil {
+		// Everything up to connecting is checking the flags
+		checkingFlags := true
+		defer func() {
+			if err != nil && checkingFlags {
+				err = usageError(err)
+			}
+		}()
+		if c.Args().Len() > 2 {
+			return fmt.Errorf("Expected a database and an optional query but got %d arguments", c.Args().Len())
+		}
+		var sqls []string
+		exportTables := len(c.StringSlice("table")) > 0 || c.Bool("all-tables")
+		if exportTables {
+			if len(c.StringSlice("execute")) > 0 || c.Args().Len() > 1 {
+				return fmt.Errorf("--table and --all-tables cannot be used with --execute or a query argument")
+			}
+			if len(c.StringSlice("table")) > 0 && c.Bool("all-tables") {
+				return fmt.Errorf("--table and --all-tables cannot be used together")
+			}
+			for _, pattern := range c.StringSlice("exclude-tables") {
+				if _, err = path.Match(pattern, ""); err != nil {
+					return fmt.Errorf("Invalid --exclude-tables pattern %q: %w", pattern, err)
+				}
+			}
+		}
+		if !c.Bool("allow-multi-statements") {
+			for _, cmd := range c.StringSlice("init-command") {
+				if len(export.SplitStatements(cmd)) > 1 {
+					return fmt.Errorf("--init-command %q contains more than one statement, repeat --init-command for each one", cmd)
+				}
+			}
+		}
+		if !exportTables && !c.Bool("connect-only") {
+			if sqls, err = readQueries(c); err != nil {
 				return err
 			}
-			if stat.Mode()&os.ModeCharDevice != 0 {
-				return fmt.Errorf("A query must be provided")
+			if !c.Bool("allow-multi-statements") {
+				for _, query := range sqls {
+					if len(export.SplitStatements(query)) > 1 {
+						return fmt.Errorf("The query contains more than one statement, use --allow-multi-statements to run scripts")
+					}
+				}
 			}
+		}
 
-			queryBytes, err := io.ReadAll(os.Stdin)
+		var databases []string
+		if c.String("databases") != "" && c.String("databases-file") != "" {
+			return fmt.Errorf("--databases and --databases-file can't be used together")
+		}
+		if list := c.String("databases"); list != "" {
+			if databases, err = export.ParseDatabases(list, false); err != nil {
+				return fmt.Errorf("Invalid --databases: %w", err)
+			}
+		} else if file := c.String("databases-file"); file != "" {
+			contents, err := os.ReadFile(file)
 			if err != nil {
-				return err
+				return fmt.Errorf("Error reading --databases-file: %w", err)
+			}
+			if databases, err = export.ParseDatabases(string(contents), true); err != nil {
+				return fmt.Errorf("Invalid --databases-file %s: %w", file, err)
 			}
-			query = string(queryBytes)
 		}
-
-		if strings.TrimSpace(query) == "" {
-			return fmt.Errorf("A query must be provided")
+		if len(databases) > 0 {
+			if c.Args().First() != "" {
+				return fmt.Errorf("--databases can't be used with a database argument, give the query with --execute or on stdin")
+			}
+			if c.String("output") != "" && !export.OutputHasDatabase(c.String("output")) {
+				return fmt.Errorf("--databases needs {database} in --output so each database is written to its own file, e.g. -o \"{database}-%%d.csv\"")
+			}
+			if c.Bool("all-tables") || c.String("paginate-column") != "" || c.String("watermark-column") != "" {
+				return fmt.Errorf("--databases can't be used with --all-tables, --paginate-column or --watermark-column")
+			}
 		}
 
 		password := c.String("password")
@@ -105,76 +656,432 @@ var app = cli.App{
 			// TODO: figure out how to prompt for password while also getting a piped query from stdin
 		}
 
+		httpHeaders, err := export.ParseHTTPHeaders(c.StringSlice("http-header"))
+		if err != nil {
+			return
+		}
+
+		format := c.String("format")
+		if c.Bool("pretty") {
+			format = export.FormatTable
+		} else if !c.IsSet("format") {
+			if detected := export.FormatFor(c.String("output")); detected != "" {
+				slog.Debug("detected the format from the output extension", "format", detected)
+				format = detected
+			}
+		}
+		rowTemplate := c.String("template")
+		if templateFile := c.String("template-file"); templateFile != "" {
+			if rowTemplate != "" {
+				return fmt.Errorf("--template and --template-file can't be used together")
+			}
+			data, err := os.ReadFile(templateFile)
+			if err != nil {
+				return fmt.Errorf("Error reading template file: %w", err)
+			}
+			rowTemplate = string(data)
+		}
+		commentPrefix := ""
+		if c.Bool("comment") {
+			if commentPrefix = c.String("comment-prefix"); commentPrefix == "" {
+				return fmt.Errorf("--comment-prefix can't be empty")
+			}
+		}
+		var quoteColumns []string
+		for _, columns := range c.StringSlice("quote-columns") {
+			quoteColumns = append(quoteColumns, strings.Split(columns, ",")...)
+		}
+		var safeExcelPrefix string
+		if c.Bool("safe-excel") {
+			if safeExcelPrefix, err = export.ParseSafeExcelPrefix(c.String("safe-excel-prefix")); err != nil {
+				return
+			}
+		}
+		masks, err := export.ParseMasks(c.StringSlice("mask"))
+		if err != nil {
+			return
+		}
+		replacements, err := export.ParseReplacements(c.StringSlice("replace"), c.StringSlice("replace-regex"))
+		if err != nil {
+			return
+		}
+		addColumns, err := export.ParseAddColumns(c.StringSlice("add-column"))
+		if err != nil {
+			return
+		}
+		if position := c.String("add-column-position"); position != "append" && position != "prepend" {
+			return fmt.Errorf("Invalid --add-column-position %q, expected append or prepend", position)
+		}
+		writeBuffer, err := parseByteSize(c.String("write-buffer"))
+		if err != nil {
+			return
+		}
+		jobs := c.Int("jobs")
+		var blobColumns []string
+		for _, columns := range c.StringSlice("blob-column") {
+			blobColumns = append(blobColumns, strings.Split(columns, ",")...)
+		}
+		var expectRows *int
+		var expectRowsQuery string
+		if expect := strings.TrimSpace(c.String("expect-rows")); expect != "" {
+			if n, err := strconv.Atoi(expect); err == nil {
+				expectRows = &n
+			} else {
+				if len(databases) > 0 {
+					return fmt.Errorf("--expect-rows can only be a number with --databases, a query would only count the rows of one database")
+				}
+				expectRowsQuery = expect
+			}
+		}
+		watermarkFile := c.String("watermark-file")
+		if (watermarkFile == "") != (c.String("watermark-column") == "") {
+			return fmt.Errorf("--watermark-file and --watermark-column must be used together")
+		}
+		sampleSeed := uint64(c.Int("seed"))
+		if !c.IsSet("seed") {
+			sampleSeed = rand.Uint64()
+		}
+		if c.String("paginate-column") != "" {
+			if exportTables || jobs > 1 || len(sqls) > 1 {
+				return fmt.Errorf("--paginate-column can't be used with --table, --all-tables, --jobs or more than one --execute")
+			}
+		}
+		if c.Bool("qualify-columns") {
+			if c.Bool("truncate-table-names") {
+				return fmt.Errorf("--qualify-columns and --truncate-table-names can't be used together")
+			}
+			// The wrapping query becomes the table of every column
+			if c.String("paginate-column") != "" || c.String("watermark-column") != "" || c.Bool("merge-hosts") {
+				return fmt.Errorf("--qualify-columns can't be used with --paginate-column, --watermark-column or --merge-hosts since they wrap the query")
+			}
+		}
+		var hostEntries []hostEntry
+		if list := c.String("hosts"); list != "" {
+			if hostEntries, err = parseHostList(list, c.String("user"), c.Int("port")); err != nil {
+				return
+			}
+			if !c.Bool("merge-hosts") && !export.OutputHasHost(c.String("output")) && !c.Bool("connect-only") {
+				return fmt.Errorf("--hosts needs {host} in --output so each host is written to its own files, e.g. -o \"{host}-%%d.csv\", or --merge-hosts to write them to one output")
+			}
+			if watermarkFile != "" || expectRowsQuery != "" {
+				return fmt.Errorf("--hosts can't be used with --watermark-file or an --expect-rows query")
+			}
+		} else if c.Bool("merge-hosts") || c.Bool("fail-fast") {
+			return fmt.Errorf("--merge-hosts and --fail-fast need --hosts")
+		}
+		// The pool defaults are the same as database/sql's except that
+		// parallel exports need a connection for each job
+		maxOpenConns := c.Int("max-open-conns")
+		if !c.IsSet("max-open-conns") && jobs > 1 {
+			maxOpenConns = jobs
+		}
+		if maxOpenConns < 0 || maxOpenConns > 0 && maxOpenConns < jobs {
+			return fmt.Errorf("--max-open-conns must be 0 or at least the number of --jobs")
+		}
+		if c.Duration("conn-max-lifetime") < 0 {
+			return fmt.Errorf("--conn-max-lifetime can't be negative")
+		}
+		if jobs > 1 && !export.OutputCreatesMultipleFiles(c.String("output")) && !(len(databases) > 0 && export.OutputHasDatabase(c.String("output"))) {
+			return fmt.Errorf("--jobs requires an output template that creates a separate file for each query, such as -o output-%%d.csv")
+		}
+
 		database := c.Args().First()
-		if database == "" {
+		if database == "" && len(databases) == 0 {
 			database = os.Getenv("MYSQL_DATABASE")
 		}
 
-		dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?multiStatements=true", c.String("user"), password, c.String("host"), c.Int("port"), database)
-		if password == "" {
-			dsn = fmt.Sprintf("%s@tcp(%s:%d)/%s?multiStatements=true", c.String("user"), c.String("host"), c.Int("port"), database)
+		addrs, err := parseHosts(c.String("host"), c.Int("port"))
+		if err != nil {
+			return
+		}
+		cfg := mysqlConfig(c.String("user"), password, addrs, database)
+		cfg.MultiStatements = c.Bool("allow-multi-statements")
+		cfg.ColumnsWithAlias = c.Bool("qualify-columns")
+		cfg.AllowNativePasswords = c.Bool("allow-native-passwords")
+		cfg.AllowOldPasswords = c.Bool("allow-old-passwords")
+		cfg.AllowCleartextPasswords = c.Bool("allow-cleartext-passwords")
+		if zone := c.String("time-zone"); zone != "" {
+			cfg.Params = map[string]string{"time_zone": timeZoneParam(zone)}
+		}
+		if c.Bool("ssl-verify-skip") {
+			slog.Warn("--ssl-verify-skip is set, the server's certificate isn't verified so the connection can be intercepted")
+			cfg.TLSConfig = "skip-verify"
 		}
 
-		passwordLessDsn := strings.ReplaceAll(dsn, password, "******")
-		db, err := sql.Open("mysql", dsn)
-		if err != nil {
-			return fmt.Errorf("Error connecting to database (%s): %w", passwordLessDsn, err)
+		ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		exporter := export.Exporter{
+			Output: export.OutputData{
+				OutputTemplate: c.String("output"),
+				Database:       database,
+				Host:           outputHost(addrs),
+				S3:             &export.S3Destination{Context: ctx, Region: c.String("aws-region")},
+				HTTP:           &export.HTTPDestination{Context: ctx, Headers: httpHeaders},
+				Format:         format,
+				Compress:       c.String("compress"),
+				Encoding:       c.String("encoding"),
+				EncodingErrors: c.String("encoding-errors"),
+				Checksum:       c.Bool("checksum"),
+				WriteBuffer:    int(writeBuffer),
+				Tee:            c.Bool("tee"),
+				OnExisting:     c.String("on-duplicate-file"),
+			},
+			WriteOptions: export.WriteOptions{
+				NoHeader:           c.Bool("no-header"),
+				Format:             format,
+				QuoteChar:          c.String("quote-char"),
+				Quote:              c.String("quote"),
+				QuoteColumns:       quoteColumns,
+				EscapeChar:         c.String("escape-char"),
+				QuoteEmpty:         c.Bool("quote-empty"),
+				HeaderPrefix:       c.String("header-prefix"),
+				HeaderSuffix:       c.String("header-suffix"),
+				CommentPrefix:      commentPrefix,
+				HeaderOnly:         c.Bool("header-only"),
+				DedupeHeaders:      c.Bool("dedupe-headers"),
+				TruncateTableNames: c.Bool("truncate-table-names"),
+				TypesHeader:        c.Bool("types-header"),
+				Transpose:          c.Bool("transpose"),
+				Profile:            c.Bool("profile-columns"),
+				Template:           rowTemplate,
+				KeyColumn:          c.String("manifest-key"),
+				WatermarkColumn:    c.String("watermark-column"),
+				Typed:              c.Bool("typed"),
+				JSONOmitNull:       c.Bool("json-omit-null"),
+				DecimalAsNumber:    c.Bool("decimal-as-number"),
+				BoolColumns:        c.StringSlice("bool-column"),
+				TrimSpace:          c.Bool("trim-space"),
+				ReplaceNewlines:    c.IsSet("replace-newlines"),
+				NewlineReplacement: c.String("replace-newlines"),
+				GeometryFormat:     c.String("geometry-format"),
+				Masks:              masks,
+				MaskSalt:           c.String("mask-salt"),
+				NullIfs:            export.ParseNullIfs(c.StringSlice("null-if")),
+				SafeExcelPrefix:    safeExcelPrefix,
+				Replacements:       replacements,
+				PartitionBy:        c.String("partition-by"),
+				BlobDir:            c.String("blob-dir"),
+				BlobColumns:        blobColumns,
+				BlobKey:            c.String("blob-key"),
+				Footer:             c.String("footer"),
+				Sample:             c.Int("sample"),
+				SampleSeed:         sampleSeed,
+			},
+			SkipEmptyResultSets: c.Bool("skip-empty"),
+			KeepGoing:           c.Bool("keep-going"),
+			StrictColumns:       c.Bool("strict-columns"),
+			AddColumns:          addColumns,
+			PrependColumns:      c.String("add-column-position") == "prepend",
+			MergeHosts:          c.Bool("merge-hosts"),
+			FailFast:            c.Bool("fail-fast"),
+			ColumnTypes:         c.Bool("column-types"),
+			Metadata:            c.Bool("metadata"),
+			FooterFile:          c.Bool("footer-file"),
+			InitCommands:        c.StringSlice("init-command"),
+			ReadOnly:            c.Bool("read-only"),
+			SingleTransaction:   c.Bool("single-transaction"),
+			Prepared:            c.Bool("prepared"),
+			ShowWarnings:        c.Bool("show-warnings"),
+			Strict:              c.Bool("strict"),
+			Jobs:                jobs,
+			PaginateColumn:      c.String("paginate-column"),
+			PageSize:            c.Int("page-size"),
+			MaxRowsTotal:        c.Int("max-rows-total"),
+			QueryRetries:        c.Int("query-retries"),
+			MaxRowsPerSecond:    c.Int("max-rows-per-second"),
+			RetryDelay:          c.Duration("query-retry-delay"),
+			OnComplete:          c.String("on-complete"),
+			IgnoreHookErrors:    c.Bool("on-complete-ignore-errors"),
+			Version:             strings.TrimSpace(version),
+			ExpectRows:          expectRows,
+			ExpectRowsQuery:     expectRowsQuery,
 		}
-		defer db.Close()
-		rows, err := db.Query(query)
-		if err != nil {
-			return fmt.Errorf("Error executing query (%s) on (%s): %w", query, passwordLessDsn, err)
+		if err = exporter.Validate(); err != nil {
+			return
+		}
+		if c.String("output") == "" && export.CompressionFor(c.String("compress"), "") != export.CompressNone {
+			if stat, err := os.Stdout.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+				return fmt.Errorf("Refusing to write compressed output to a terminal")
+			}
+		}
+		if watermarkFile != "" {
+			if exporter.Since, err = export.ReadWatermark(watermarkFile); err != nil {
+				return fmt.Errorf("Error reading watermark file: %w", err)
+			}
+			if exporter.Since == nil {
+				slog.Info(fmt.Sprintf("%s doesn't exist yet, exporting every row", watermarkFile), "watermark_file", watermarkFile)
+			}
+		}
+		if c.Bool("tee") {
+			// A closed pipe, e.g. from head exiting, would otherwise kill the
+			// process before the partial files are removed
+			signal.Ignore(syscall.SIGPIPE)
 		}
-		defer rows.Close()
 
-		hasResultSet := true
-		outputData := OutputData{
-			OutputTemplate: c.String("output"),
+		checkingFlags = false
+		passwordLessDsn := maskedDSN(cfg)
+		// The connector takes the config directly. FormatDSN doesn't escape the
+		// password so a DSN string can't carry one containing / @ : or ?
+		openDB := func(connector driver.Connector) *sql.DB {
+			db := sql.OpenDB(connector)
+			db.SetMaxOpenConns(maxOpenConns)
+			db.SetMaxIdleConns(c.Int("max-idle-conns"))
+			db.SetConnMaxLifetime(c.Duration("conn-max-lifetime"))
+			return db
 		}
-		var prevCols []string
-		for hasResultSet {
-			cols, err := rows.Columns()
+		var db *sql.DB
+		var hosts []export.Host
+		if len(hostEntries) == 0 {
+			connector, err := newConnector(cfg, addrs)
 			if err != nil {
-				return err
+				return connectionError(passwordLessDsn, fmt.Errorf("Error connecting to database (%s): %w", passwordLessDsn, err))
+			}
+			db = openDB(connector)
+			defer db.Close()
+		}
+		for _, h := range hostEntries {
+			hostCfg := cfg.Clone()
+			hostCfg.User, hostCfg.Addr = h.user, h.addr
+			connector, err := mysql.NewConnector(hostCfg)
+			if err != nil {
+				return connectionError(maskedDSN(hostCfg), fmt.Errorf("Error connecting to database (%s): %w", maskedDSN(hostCfg), err))
 			}
-			if len(cols) != len(prevCols) && len(prevCols) > 0 && !outputCreatesMultipleFiles(outputData.OutputTemplate) {
-				return fmt.Errorf("The number of columns in each result set must be the same when writing to stdout or a valid output template must be provided")
+			hostDB := openDB(connector)
+			defer hostDB.Close()
+			hosts = append(hosts, export.Host{Name: h.name, DB: hostDB, MaskedDSN: maskedDSN(hostCfg)})
+		}
+		if len(hosts) > 0 {
+			// Tables are looked up on the first host
+			db, passwordLessDsn = hosts[0].DB, hosts[0].MaskedDSN
+		}
+		exporter.DB, exporter.MaskedDSN, exporter.Hosts = db, passwordLessDsn, hosts
+		exporter.Output.Started = time.Now()
+
+		if c.Bool("connect-only") {
+			ctx, cancel := context.WithTimeout(ctx, c.Duration("connect-timeout"))
+			defer cancel()
+			servers := hosts
+			if len(servers) == 0 {
+				servers = []export.Host{{DB: db, MaskedDSN: passwordLessDsn}}
+			}
+			for _, server := range servers {
+				if err = server.DB.PingContext(ctx); err != nil {
+					return connectionError(server.MaskedDSN, fmt.Errorf("Error connecting to database (%s): %w", server.MaskedDSN, err))
+				}
+			}
+			return
+		}
+
+		defer exporter.Output.S3.LogSummary()
+		if c.Bool("stats") {
+			defer func() { exporter.LogStats(time.Since(exporter.Output.Started)) }()
+		}
+		if exporter.SkipEmptyResultSets {
+			defer exporter.LogEmptySummary()
+		}
+		if safeExcelPrefix != "" {
+			defer exporter.LogSanitizedSummary()
+		}
+
+		for _, query := range sqls {
+			exporter.Queries = append(exporter.Queries, export.Query{SQL: query})
+		}
+		if exportTables {
+			tables := c.StringSlice("table")
+			if c.Bool("all-tables") {
+				tables, err = export.ListTables(ctx, db, c.StringSlice("exclude-tables"), c.Bool("skip-views"))
+			} else if len(databases) == 0 {
+				// With --databases a missing table fails the query of that
+				// database
+				err = export.CheckTablesExist(ctx, db, tables)
 			}
-			prevCols = cols
-			output, err := getOutput(outputData)
 			if err != nil {
-				return fmt.Errorf("Error getting output: %w", err)
+				return fmt.Errorf("Error finding tables on (%s): %w", passwordLessDsn, err)
 			}
-			if err = writeResultSet(rows, output, c.Bool("no-header")); err != nil {
-				return fmt.Errorf("Error writing result set: %w", err)
+			exporter.Queries = export.TableQueries(tables, c.String("where"))
+			defer exporter.LogTableSummary()
+		}
+		if len(databases) > 0 {
+			exporter.Queries = export.DatabaseQueries(exporter.Queries, databases)
+			defer exporter.LogDatabaseSummary()
+		}
+
+		err = exporter.Run(ctx)
+		if err != nil {
+			return
+		}
+		if watermarkFile != "" {
+			// Without any new rows the previous watermark still applies
+			if watermark := exporter.Watermark(); watermark != nil {
+				if err = export.WriteWatermark(watermarkFile, *watermark); err != nil {
+					return &export.OutputError{Err: fmt.Errorf("Error writing watermark file: %w", err)}
+				}
 			}
-			hasResultSet = rows.NextResultSet()
-			outputData.FileNum++
 		}
+		if schemaFile := c.String("schema-file"); schemaFile != "" {
+			if err = export.WriteSchemaFile(schemaFile, exporter.Results); err != nil {
+				return &export.OutputError{Err: fmt.Errorf("Error writing schema file: %w", err)}
+			}
+		}
+		if manifest := c.String("manifest"); manifest != "" {
+			if err = export.WriteManifest(manifest, exporter.Results); err != nil {
+				return &export.OutputError{Err: fmt.Errorf("Error writing manifest: %w", err)}
+			}
+		}
+		rows := 0
+		for _, r := range exporter.Results {
+			rows += r.Rows
+		}
+		slog.Debug("export finished", "result_sets", len(exporter.Results), "rows", rows, "duration_ms", time.Since(exporter.Output.Started).Milliseconds())
 		return
 	},
 }
 
-type OutputData struct {
-	OutputTemplate string
-	FileNum        int
-}
+// readQueries returns the queries from --execute, the argument after the
+// database or stdin, in that order. Giving a query argument along with
+// --execute or piped input is an error rather than silently ignoring one.
+func readQueries(c *cli.Context) (queries []string, err error) {
+	argQuery := c.Args().Get(1)
+	for _, query := range c.StringSlice("execute") {
+		if strings.TrimSpace(query) != "" {
+			queries = append(queries, query)
+		}
+	}
+	if len(queries) > 0 {
+		if argQuery != "" {
+			return nil, fmt.Errorf("A query can't be given as an argument and with --execute")
+		}
+		return
+	}
 
-func getOutput(data OutputData) (output io.WriteCloser, err error) {
-	output = NopCloser{os.Stdout}
-	if data.OutputTemplate != "" {
-		filename := data.OutputTemplate
-		if outputCreatesMultipleFiles(filename) {
-			filename = fmt.Sprintf(filename, data.FileNum)
-		}
-		if filename != "" {
-			output, err = os.Create(filename)
-			if err != nil {
-				return nil, err
-			}
+	// Try reading the query from stdin if it wasn't provided as an argument
+	stat, err := os.Stdin.Stat()
+	if err != nil {
+		return nil, err
+	}
+	piped := stat.Mode()&os.ModeCharDevice == 0
+	if argQuery != "" {
+		if piped {
+			return nil, fmt.Errorf("A query can't be given as an argument and on stdin, redirect stdin from /dev/null to use the argument")
+		}
+		if strings.TrimSpace(argQuery) == "" {
+			return nil, fmt.Errorf("A query must be provided")
 		}
+		return []string{argQuery}, nil
+	}
+	if !piped {
+		return nil, fmt.Errorf("A query must be provided")
+	}
+
+	queryBytes, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, err
 	}
-	return
+	if strings.TrimSpace(string(queryBytes)) == "" {
+		return nil, fmt.Errorf("A query must be provided")
+	}
+	return []string{string(queryBytes)}, nil
 }
 
 func formatUsageString(s string) string {
@@ -196,64 +1103,14 @@ func iterativeReplaceAll(s string, from []string, to string) string {
 	return s
 }
 
-var hasPercentD = regexp.MustCompile("%(0\\d)?d")
-
-func outputCreatesMultipleFiles(outputTemplate string) bool {
-	return hasPercentD.MatchString(outputTemplate)
-}
-
-func writeResultSet(rows *sql.Rows, output io.WriteCloser, noHeader bool) (err error) {
-	defer output.Close()
-	writer := csv.NewWriter(output)
-	defer writer.Flush()
-	columns, err := rows.Columns()
-	if err != nil {
-		return
-	}
-	if !noHeader {
-		if err = writer.Write(columns); err != nil {
-			return
-		}
-	}
-	values := make([]interface{}, len(columns))
-	stringVals := make([]string, len(columns))
-	for i := range values {
-		values[i] = &sql.RawBytes{}
-	}
-
-	for rows.Next() {
-		if err = rows.Err(); err != nil {
-			return
-		}
-		if err = rows.Scan(values...); err != nil {
-			return
-		}
-		for i, val := range values {
-			v := val.(*sql.RawBytes)
-			stringVals[i] = string(*v)
-		}
-		if err = writer.Write(stringVals); err != nil {
-			return
-		}
-	}
-	return
-}
-
-type NopCloser struct {
-	io.Writer
-}
-
-func (NopCloser) Close() error {
-	return nil
-}
-
 func main() {
 	cli.HelpFlag = &cli.BoolFlag{
 		Name:  "help",
 		Usage: "Show help",
 	}
+	setupLogging(os.Stderr, LogText, false, false)
 	if err := app.Run(os.Args); err != nil {
-		fmt.Fprintln(os.Stderr, "error:", err)
-		os.Exit(1)
+		printError(err)
+		os.Exit(exitCode(err))
 	}
 }
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30},
+	{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+	{"k", 1 << 10}, {"m", 1 << 20}, {"g", 1 << 30},
+	{"b", 1},
+}
+
+// parseByteSize parses a size such as 512, 64KiB or 1MB. Every unit is a power
+// of 1024.
+func parseByteSize(s string) (int64, error) {
+	number := strings.ToLower(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(number, u.suffix) {
+			number = strings.TrimSpace(strings.TrimSuffix(number, u.suffix))
+			multiplier = u.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("Invalid size %q, expected a number of bytes like 65536 or 64KiB", s)
+	}
+	return n * multiplier, nil
+}